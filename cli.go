@@ -0,0 +1,401 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// DefaultConfigFile 未指定 -c 时使用的配置文件
+const DefaultConfigFile = "config.yml"
+
+// stopTimeout 等待后台进程退出的最长时间
+const stopTimeout = 30 * time.Second
+
+// 子命令的退出码
+const (
+	ExitOK         = 0
+	ExitFailure    = 1 // 同步失败、配置无效或停止失败
+	ExitUsage      = 2 // 参数错误或配置无法加载
+	ExitNotRunning = 3 // status：后台进程未运行
+)
+
+// runCLI 解析子命令并执行，返回进程退出码
+// 第一个参数不是子命令时按 run 处理，兼容旧的 -d、-c 等参数
+func runCLI(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stdout)
+		return ExitOK
+	}
+
+	command := "run"
+	if !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "run":
+		return runCommand(args)
+	case "sync":
+		return syncCommand(args)
+	case "validate":
+		return validateCommand(args)
+	case "status":
+		return statusCommand(args)
+	case "stop":
+		return stopCommand(args)
+	case "history":
+		if err := runHistory(args, os.Stdout); err != nil {
+			if err == flag.ErrHelp {
+				return ExitOK
+			}
+			fmt.Fprintf(os.Stderr, "Failed to read run history: %v\n", err)
+			return ExitFailure
+		}
+		return ExitOK
+	case "version":
+		printVersion()
+		return ExitOK
+	case "help":
+		printUsage(os.Stdout)
+		return ExitOK
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", command)
+		printUsage(os.Stderr)
+		return ExitUsage
+	}
+}
+
+// printUsage 输出命令的用法
+func printUsage(w io.Writer) {
+	fmt.Fprint(w, Banner)
+	fmt.Fprintf(w, "Git-Syncer %s\n\n", Version)
+	fmt.Fprintln(w, "Usage:")
+	fmt.Fprintf(w, "  %s <command> [options]\n", os.Args[0])
+	fmt.Fprintf(w, "  %s [options]            same as run, for compatibility\n\n", os.Args[0])
+	fmt.Fprintln(w, "Commands:")
+	fmt.Fprintln(w, "  run           Start the scheduler and file watchers (-d to run as daemon)")
+	fmt.Fprintln(w, "  sync <job>    Run a single job once, exit with 1 if it fails")
+	fmt.Fprintln(w, "  validate      Check the config file")
+	fmt.Fprintln(w, "  status        Show whether the daemon is running and the last run of each job")
+	fmt.Fprintln(w, "  stop          Stop the daemon started with run -d")
+	fmt.Fprintln(w, "  history       Show past runs")
+	fmt.Fprintln(w, "  version       Show version information")
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the options of a command.\n", os.Args[0])
+}
+
+// printVersion 输出版本信息
+func printVersion() {
+	fmt.Print(Banner)
+	fmt.Printf("Git-Syncer %s (Build: %s, Commit: %s)\n", Version, BuildTime, GitCommit)
+}
+
+// runCommand 执行 run 子命令，启动调度器并阻塞
+func runCommand(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	var daemonFlag, noDaemon, showVersion, help, rehash bool
+	var configFile string
+	fs.BoolVar(&daemonFlag, "d", false, "Run as daemon")
+	fs.BoolVar(&noDaemon, "nodaemon", false, "Internal flag to prevent recursive daemon")
+	fs.BoolVar(&showVersion, "v", false, "Show version information")
+	fs.BoolVar(&showVersion, "version", false, "Show version information (same as -v)")
+	fs.StringVar(&configFile, "c", DefaultConfigFile, "Path to config file")
+	fs.BoolVar(&help, "h", false, "Show help information")
+	fs.BoolVar(&rehash, "rehash", false, "Rebuild the file index by rehashing all synced files")
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+
+	if help {
+		printUsage(os.Stdout)
+		fmt.Println("\nOptions:")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+		return ExitOK
+	}
+	if showVersion {
+		printVersion()
+		return ExitOK
+	}
+
+	if daemonFlag && !noDaemon {
+		child, release, err := startDaemon()
+		if err != nil {
+			log.Fatal("Failed to start daemon: ", err)
+		}
+		if !child {
+			return ExitOK // 后台进程已启动，退出当前进程
+		}
+		defer release()
+	}
+
+	if configFile == "" {
+		fmt.Println("No config file provided, using default: " + DefaultConfigFile)
+		configFile = DefaultConfigFile
+	}
+	if _, err := os.Stat(configFile); os.IsNotExist(err) {
+		fmt.Printf("Config file %s not found, please provide a valid config file with -c option\n", configFile)
+		return ExitUsage
+	}
+
+	sync, err := NewGitSync(configFile)
+	if err != nil {
+		log.Printf("Failed to create GitSync: %v", err)
+		return ExitFailure
+	}
+
+	if rehash {
+		if err := sync.RehashIndexes(); err != nil {
+			log.Printf("Failed to rehash file index: %v", err)
+			return ExitFailure
+		}
+	}
+
+	if err := sync.Run(); err != nil {
+		log.Printf("Failed to run GitSync: %v", err)
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// syncCommand 执行 sync 子命令，运行一次指定任务后退出
+func syncCommand(args []string) int {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	configFile := fs.String("c", DefaultConfigFile, "Path to config file")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s sync [options] <job>\n\nOptions:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return ExitUsage
+	}
+	name := fs.Arg(0)
+
+	sync, err := NewGitSync(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create GitSync: %v\n", err)
+		return ExitUsage
+	}
+	user, job, path := sync.config.findJob(name)
+	if job == nil {
+		fmt.Fprintf(os.Stderr, "Job %s not found in %s\n", name, *configFile)
+		return ExitUsage
+	}
+	// 只检查要运行的任务，其他任务的配置问题由 validate 报告
+	if !sync.checkJobConfig(validateConfig(sync.config), path, job) {
+		return ExitFailure
+	}
+	if err := sync.setupUserGitConfig(user); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config for user %s: %v\n", user.Username, err)
+		return ExitFailure
+	}
+
+	if result := sync.runJob(user, job); result.Err != nil {
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// findJob 按名称查找任务及其所属用户，path 为任务在配置文件中的 YAML 路径
+func (c *Config) findJob(name string) (user *User, job *Job, path string) {
+	for i := range c.Users {
+		user := &c.Users[i]
+		for j := range user.Jobs {
+			if user.Jobs[j].Name == name {
+				return user, &user.Jobs[j], fmt.Sprintf("users[%d].jobs[%d]", i, j)
+			}
+		}
+	}
+	return nil, nil, ""
+}
+
+// validateCommand 执行 validate 子命令，检查配置文件
+func validateCommand(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	configFile := fs.String("c", DefaultConfigFile, "Path to config file")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *configFile, err)
+		return ExitFailure
+	}
+	if errs := validateConfig(config); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *configFile, err)
+		}
+		return ExitFailure
+	}
+
+	jobs := 0
+	for _, user := range config.Users {
+		jobs += len(user.Jobs)
+	}
+	fmt.Printf("%s is valid: %d users, %d jobs, %d webhooks\n", *configFile, len(config.Users), jobs, len(config.Webhooks))
+	return ExitOK
+}
+
+// statusCommand 执行 status 子命令，显示后台进程状态和每个任务最近一次运行
+func statusCommand(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	configFile := fs.String("c", DefaultConfigFile, "Path to config file")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+
+	code := ExitOK
+	if pid, err := readPidFile(PidFile); err == nil && processRunning(pid) {
+		fmt.Printf("Git-Syncer is running (PID %d)\n", pid)
+	} else {
+		fmt.Println("Git-Syncer is not running")
+		code = ExitNotRunning
+	}
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		return ExitFailure
+	}
+	records, err := readHistory(historyPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read run history: %v\n", err)
+		return ExitFailure
+	}
+	last := make(map[string]RunRecord)
+	for _, record := range records {
+		last[record.Job] = record
+	}
+
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOB\tUSER\tTRIGGER\tLAST RUN\tSTATUS\tSTAGE\tCOMMIT")
+	for _, user := range config.Users {
+		for _, job := range user.Jobs {
+			trigger := job.Schedule
+			if job.Trigger == TriggerWatch {
+				trigger = strings.TrimSpace(TriggerWatch + " " + job.Schedule)
+			}
+			lastRun, status, stage, commit := "never", "-", "-", "-"
+			if record, ok := last[job.Name]; ok {
+				lastRun = record.Start.Local().Format("2006-01-02 15:04:05")
+				status = record.Status
+				if record.Stage != "" {
+					stage = record.Stage
+				}
+				if record.Commit != "" {
+					commit = record.Commit
+					if len(commit) > 7 {
+						commit = commit[:7]
+					}
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", job.Name, user.Username, trigger, lastRun, status, stage, commit)
+		}
+	}
+	tw.Flush()
+	return code
+}
+
+// stopCommand 执行 stop 子命令，停止 pid 文件记录的后台进程
+func stopCommand(args []string) int {
+	fs := flag.NewFlagSet("stop", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+
+	pid, err := readPidFile(PidFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Println("Git-Syncer is not running")
+			return ExitFailure
+		}
+		fmt.Fprintf(os.Stderr, "Failed to read pid file: %v\n", err)
+		return ExitFailure
+	}
+	if !processRunning(pid) {
+		fmt.Printf("Git-Syncer is not running, removing stale pid file (PID %d)\n", pid)
+		os.Remove(PidFile)
+		return ExitFailure
+	}
+
+	if err := stopProcess(pid); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to stop Git-Syncer (PID %d): %v\n", pid, err)
+		return ExitFailure
+	}
+	// 等待正在执行的同步任务完成
+	for deadline := time.Now().Add(stopTimeout); processRunning(pid); {
+		if time.Now().After(deadline) {
+			fmt.Fprintf(os.Stderr, "Git-Syncer (PID %d) did not stop within %s\n", pid, stopTimeout)
+			return ExitFailure
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	os.Remove(PidFile)
+	fmt.Printf("Git-Syncer stopped (PID %d)\n", pid)
+	return ExitOK
+}
+
+// readPidFile 读取 pid 文件中的进程号
+func readPidFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid pid file %s", path)
+	}
+	return pid, nil
+}
+
+// processRunning 检查进程是否仍在运行
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// Windows 上 FindProcess 只有进程存在时才会成功
+	if runtime.GOOS == "windows" {
+		process.Release()
+		return true
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+// stopProcess 请求进程退出，Windows 不支持 SIGTERM，直接结束进程
+func stopProcess(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		return process.Kill()
+	}
+	return process.Signal(syscall.SIGTERM)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestPidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), PidFile)
+	if _, err := readPidFile(path); !os.IsNotExist(err) {
+		t.Errorf("Expected a not exist error, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pid, err := readPidFile(path)
+	if err != nil || pid != os.Getpid() {
+		t.Fatalf("readPidFile() = %d, %v", pid, err)
+	}
+	if !processRunning(pid) {
+		t.Errorf("Expected the current process to be running")
+	}
+
+	if err := os.WriteFile(path, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readPidFile(path); err == nil {
+		t.Errorf("Expected an error for an invalid pid file")
+	}
+}
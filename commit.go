@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultCommitMessage 未配置 commit_message 时使用的提交信息模板
+const DefaultCommitMessage = `Sync update by {{.User.Username}}: {{.Time.Format "2006-01-02 15:04:05"}}`
+
+// templateFuncs 提交信息和 webhook 模板可用的函数
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// ChangeSet 暂存区中相对上一次提交的文件变化，路径为仓库内以 / 分隔的相对路径
+type ChangeSet struct {
+	Added        []string
+	Modified     []string
+	Deleted      []string
+	Renamed      []RenamedFile
+	Bytes        int64 // 新增、修改和重命名后文件的总大小
+	DeletedBytes int64 // 删除文件的总大小
+}
+
+// Files 返回全部变化的文件，重命名的文件使用新路径
+func (c ChangeSet) Files() []string {
+	var files []string
+	files = append(files, c.Added...)
+	files = append(files, c.Modified...)
+	files = append(files, c.Deleted...)
+	for _, r := range c.Renamed {
+		files = append(files, r.To)
+	}
+	return files
+}
+
+// CommitMessageData 提交信息模板的数据，Job 中的认证信息已被隐藏
+type CommitMessageData struct {
+	Job  Job
+	User CommitUser
+	Host string
+	Time time.Time
+	*ChangeSet
+}
+
+// CommitUser 提交信息模板中可用的用户信息，不包含 git_password 等凭据
+type CommitUser struct {
+	Username string
+	Email    string
+}
+
+// stagedChanges 读取暂存区中的文件变化
+func stagedChanges(user *User, repoPath string) (*ChangeSet, error) {
+	// 尚无提交时 git 会将全部暂存文件列为新增
+	output, err := gitCommand(user, repoPath, "diff", "--cached", "--name-status", "-M", "-z").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list staged changes: %v", err)
+	}
+	changes := parseNameStatus(string(output))
+
+	var written, deleted []string
+	for _, path := range append(append([]string{}, changes.Added...), changes.Modified...) {
+		written = append(written, ":"+path)
+	}
+	for _, r := range changes.Renamed {
+		written = append(written, ":"+r.To)
+	}
+	for _, path := range changes.Deleted {
+		deleted = append(deleted, "HEAD:"+path)
+	}
+	changes.Bytes = objectSizes(user, repoPath, written)
+	changes.DeletedBytes = objectSizes(user, repoPath, deleted)
+	return changes, nil
+}
+
+// objectSizes 返回给定 git 对象的大小之和，不存在的对象被忽略
+func objectSizes(user *User, repoPath string, objects []string) int64 {
+	if len(objects) == 0 {
+		return 0
+	}
+	cmd := gitCommand(user, repoPath, "cat-file", "--batch-check=%(objectsize)")
+	cmd.Stdin = strings.NewReader(strings.Join(objects, "\n") + "\n")
+	output, err := cmd.Output()
+	if err != nil {
+		return 0
+	}
+
+	var total int64
+	for _, line := range strings.Split(string(output), "\n") {
+		// 不存在的对象输出 "<name> missing"
+		if size, err := strconv.ParseInt(strings.TrimSpace(line), 10, 64); err == nil {
+			total += size
+		}
+	}
+	return total
+}
+
+// ChangeSummary 一次同步产生的变化，用于日志和 webhook
+type ChangeSummary struct {
+	ChangeSet
+	Commit    string // 最终推送或提交的 SHA
+	Branch    string // 提交所在的分支，拉取请求模式下为拉取请求分支
+	RemoteURL string // 隐藏认证信息后的远程地址
+}
+
+// changeSummary 汇总本次提交的变化
+func changeSummary(user *User, job *Job, changes *ChangeSet, branch string) ChangeSummary {
+	summary := ChangeSummary{ChangeSet: *changes, Branch: branch, RemoteURL: redactURL(job.RemoteURL)}
+	if output, err := gitCommand(user, job.GetRepoPath(), "rev-parse", "HEAD").Output(); err == nil {
+		summary.Commit = strings.TrimSpace(string(output))
+	}
+	return summary
+}
+
+// String 返回变化的简要描述
+func (s ChangeSummary) String() string {
+	commit := s.Commit
+	if len(commit) > 7 {
+		commit = commit[:7]
+	}
+	summary := fmt.Sprintf("%d added, %d modified, %d deleted, %d renamed (%d bytes written, %d bytes deleted) in commit %s on %s",
+		len(s.Added), len(s.Modified), len(s.Deleted), len(s.Renamed), s.Bytes, s.DeletedBytes, commit, s.Branch)
+	if s.RemoteURL != "" {
+		summary += " at " + s.RemoteURL
+	}
+	return summary
+}
+
+// parseNameStatus 解析 git diff --name-status -z 的输出
+func parseNameStatus(output string) *ChangeSet {
+	changes := &ChangeSet{}
+	fields := strings.Split(strings.TrimSuffix(output, "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		status, path := fields[i], fields[i+1]
+		switch status[0] {
+		case 'A':
+			changes.Added = append(changes.Added, path)
+		case 'D':
+			changes.Deleted = append(changes.Deleted, path)
+		case 'R', 'C':
+			if i+2 >= len(fields) {
+				return changes
+			}
+			if status[0] == 'R' {
+				changes.Renamed = append(changes.Renamed, RenamedFile{From: path, To: fields[i+2]})
+			} else {
+				changes.Added = append(changes.Added, fields[i+2])
+			}
+			i++
+		default:
+			changes.Modified = append(changes.Modified, path)
+		}
+	}
+	return changes
+}
+
+// commitMessage 根据任务的 commit_message 和 commit_trailers 模板生成提交信息
+func commitMessage(user *User, job *Job, changes *ChangeSet) (string, error) {
+	host, _ := os.Hostname()
+	data := CommitMessageData{
+		Job:       redactJob(*job),
+		User:      CommitUser{Username: user.Username, Email: user.Email},
+		Host:      host,
+		Time:      time.Now(),
+		ChangeSet: changes,
+	}
+
+	text := job.CommitMessage
+	if text == "" {
+		text = DefaultCommitMessage
+	}
+	message, err := renderCommitTemplate("commit_message", text, data)
+	if err != nil {
+		return "", err
+	}
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return "", fmt.Errorf("commit_message rendered an empty message")
+	}
+
+	var trailers []string
+	for i, text := range job.CommitTrailers {
+		trailer, err := renderCommitTemplate(fmt.Sprintf("commit_trailers[%d]", i), text, data)
+		if err != nil {
+			return "", err
+		}
+		if trailer = strings.TrimSpace(trailer); trailer != "" {
+			trailers = append(trailers, trailer)
+		}
+	}
+	if len(trailers) > 0 {
+		message += "\n\n" + strings.Join(trailers, "\n")
+	}
+	return message, nil
+}
+
+// renderCommitTemplate 解析并执行提交信息模板
+func renderCommitTemplate(name, text string, data CommitMessageData) (string, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s template: %v", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to execute %s template: %v", name, err)
+	}
+	return buf.String(), nil
+}
+
+// validateCommitTemplates 检查任务的提交信息模板语法
+func validateCommitTemplates(job *Job) error {
+	templates := map[string]string{"commit_message": job.CommitMessage}
+	for i, text := range job.CommitTrailers {
+		templates[fmt.Sprintf("commit_trailers[%d]", i)] = text
+	}
+	for _, name := range sortedKeys(templates) {
+		if _, err := template.New(name).Funcs(templateFuncs).Parse(templates[name]); err != nil {
+			return fmt.Errorf("invalid %s template: %v", name, err)
+		}
+	}
+	return nil
+}
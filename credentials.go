@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// git-syncer 作为 GIT_ASKPASS 程序运行时使用的环境变量
+const (
+	askpassEnv         = "GIT_SYNCER_ASKPASS"
+	askpassUsernameEnv = "GIT_SYNCER_USERNAME"
+	askpassPasswordEnv = "GIT_SYNCER_PASSWORD"
+)
+
+// isAskpass 检查当前进程是否由 git 作为 GIT_ASKPASS 程序启动
+func isAskpass() bool {
+	return os.Getenv(askpassEnv) == "1"
+}
+
+// runAskpass 根据 git 的提示输出用户名或密码
+// git 以提示文本作为唯一参数调用，例如 "Username for 'https://github.com': "
+func runAskpass(args []string) int {
+	prompt := ""
+	if len(args) > 0 {
+		prompt = strings.ToLower(args[0])
+	}
+
+	switch {
+	case strings.HasPrefix(prompt, "username"):
+		fmt.Println(os.Getenv(askpassUsernameEnv))
+	case strings.HasPrefix(prompt, "password"):
+		fmt.Println(os.Getenv(askpassPasswordEnv))
+	default:
+		fmt.Fprintf(os.Stderr, "git-syncer: unexpected askpass prompt: %q\n", prompt)
+		return 1
+	}
+	return 0
+}
+
+// credentialEnv 返回通过 git-syncer 自身提供 HTTPS 认证信息的环境变量
+// 认证信息只存在于子进程环境中，不会写入 .git/config 或凭据存储
+func (u *User) credentialEnv() []string {
+	if u.GitUsername == "" && u.GitPassword == "" {
+		return nil
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil
+	}
+	return []string{
+		"GIT_ASKPASS=" + executable,
+		"GIT_TERMINAL_PROMPT=0",
+		askpassEnv + "=1",
+		askpassUsernameEnv + "=" + u.GitUsername,
+		askpassPasswordEnv + "=" + u.GitPassword,
+	}
+}
+
+// credentialConfig 返回提供 HTTPS 认证信息时使用的 git 配置项
+func (u *User) credentialConfig() [][2]string {
+	if u.GitUsername == "" && u.GitPassword == "" {
+		return nil
+	}
+	// 清空凭据助手，避免认证信息被保存到系统凭据存储
+	return [][2]string{{"credential.helper", ""}}
+}
+
+// splitCredentials 从 URL 中移除密码形式的认证信息，返回干净的 URL 和解码后的用户名、密码
+// 只有用户名的 URL 和非 URL 形式的地址（如 git@host:repo.git）原样返回
+func splitCredentials(rawURL string) (cleanURL, username, password string) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil || u.Scheme == "" {
+		return rawURL, "", ""
+	}
+	password, ok := u.User.Password()
+	if !ok {
+		return rawURL, "", ""
+	}
+	username = u.User.Username()
+	u.User = nil
+	return u.String(), username, password
+}
+
+// withURLCredentials 返回使用 remote_url 中内嵌认证信息的用户副本
+// 用户已配置 git_username/git_password 时保持不变
+func (u *User) withURLCredentials(remoteURL string) *User {
+	_, username, password := splitCredentials(remoteURL)
+	if password == "" || u.GitUsername != "" || u.GitPassword != "" {
+		return u
+	}
+	copied := *u
+	copied.GitUsername = username
+	copied.GitPassword = password
+	return &copied
+}
+
+// redactURL 返回隐藏认证信息后的 URL，用于日志和 webhook
+func redactURL(rawURL string) string {
+	cleanURL, username, _ := splitCredentials(rawURL)
+	if username == "" {
+		return cleanURL
+	}
+	return strings.Replace(cleanURL, "://", "://"+RedactedText+"@", 1)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// Rule 定义一条 gitignore 风格的匹配规则
+type Rule struct {
+	Source  string // 规则来源，例如 includes、excludes 或忽略文件路径
+	Index   int    // 规则在来源中的序号（从 1 开始）
+	Base    string // 规则所在目录（相对源目录），为空表示源目录本身
+	Text    string // 原始规则文本
+	Negate  bool   // 以 ! 开头的取反规则
+	DirOnly bool   // 以 / 结尾，仅匹配目录
+	pattern string // 转换后的 doublestar 匹配模式
+	inside  string // 以 /** 结尾时的目录部分，该目录本身不被匹配
+}
+
+// String 返回规则的可读描述，用于日志
+func (r *Rule) String() string {
+	return fmt.Sprintf("%s[%d] %q", r.Source, r.Index, r.Text)
+}
+
+// ParseRules 将 gitignore 风格的规则列表解析为 Rule
+// 空行和 # 开头的注释会被忽略
+func ParseRules(source string, lines []string) []Rule {
+	var rules []Rule
+	for i, line := range lines {
+		text := strings.TrimSpace(line)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		rule := Rule{Source: source, Index: i + 1, Text: text}
+		p := text
+		if strings.HasPrefix(p, "!") {
+			rule.Negate = true
+			p = p[1:]
+		} else if strings.HasPrefix(p, `\!`) || strings.HasPrefix(p, `\#`) {
+			p = p[1:]
+		}
+		if strings.HasSuffix(p, "/") {
+			rule.DirOnly = true
+			p = strings.TrimRight(p, "/")
+		}
+		if p == "" {
+			continue
+		}
+
+		// 包含 / 的规则相对源目录锚定，否则在任意层级匹配文件名
+		if strings.Contains(p, "/") {
+			p = strings.TrimPrefix(p, "/")
+		} else {
+			p = "**/" + p
+		}
+		rule.pattern = p
+		if strings.HasSuffix(p, "/**") {
+			rule.inside = strings.TrimSuffix(p, "/**")
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// match 检查规则是否匹配给定的相对路径
+func (r *Rule) match(path string, isDir bool) bool {
+	if r.DirOnly && !isDir {
+		return false
+	}
+	if r.Base != "" {
+		if !strings.HasPrefix(path, r.Base+"/") {
+			return false
+		}
+		path = path[len(r.Base)+1:]
+	}
+	if r.inside != "" {
+		if self, err := doublestar.Match(r.inside, path); err == nil && self {
+			return false
+		}
+	}
+	matched, err := doublestar.Match(r.pattern, path)
+	return err == nil && matched
+}
+
+// matchWithParents 检查规则是否匹配路径本身或其任一父目录
+func (r *Rule) matchWithParents(path string, isDir bool) bool {
+	if r.match(path, isDir) {
+		return true
+	}
+	for dir := parentDir(path); dir != ""; dir = parentDir(dir) {
+		if r.match(dir, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// Decision 记录一次过滤判断的结果
+type Decision struct {
+	Included bool
+	Rule     *Rule // 决定结果的规则，为 nil 表示使用默认行为
+}
+
+// Reason 返回判断结果的可读原因
+func (d Decision) Reason() string {
+	if d.Rule != nil {
+		return d.Rule.String()
+	}
+	if d.Included {
+		return "default"
+	}
+	return "no include rule matched"
+}
+
+// Filter 基于 includes/excludes 规则的文件过滤器
+//
+// 规则遵循 gitignore 语义：后出现的规则优先，! 表示取反，
+// 以 / 结尾的规则只匹配目录，包含 / 的规则相对源目录锚定。
+// 被排除的目录会整体跳过，其中的文件无法再被取反规则包含。
+type Filter struct {
+	includes []Rule
+	excludes []Rule
+}
+
+// NewFilter 根据任务的 includes/excludes 创建过滤器
+func NewFilter(includes, excludes []string) *Filter {
+	return &Filter{
+		includes: ParseRules("includes", includes),
+		excludes: ParseRules("excludes", excludes),
+	}
+}
+
+// withRules 返回追加了排除规则的新过滤器，原过滤器保持不变
+func (f *Filter) withRules(rules []Rule) *Filter {
+	if len(rules) == 0 {
+		return f
+	}
+	excludes := make([]Rule, 0, len(f.excludes)+len(rules))
+	excludes = append(excludes, f.excludes...)
+	excludes = append(excludes, rules...)
+	return &Filter{includes: f.includes, excludes: excludes}
+}
+
+// Match 判断相对源目录的路径是否应该被同步
+func (f *Filter) Match(path string, isDir bool) Decision {
+	path = strings.Trim(path, "/")
+
+	// 父目录被排除时，其中的所有文件都被排除
+	for dir := parentDir(path); dir != ""; dir = parentDir(dir) {
+		if r := lastMatch(f.excludes, dir, true, false); r != nil && !r.Negate {
+			return Decision{Included: false, Rule: r}
+		}
+	}
+	// 被取反的排除规则作为包含原因，除非包含规则另有决定
+	negated := lastMatch(f.excludes, path, isDir, false)
+	if negated != nil && !negated.Negate {
+		return Decision{Included: false, Rule: negated}
+	}
+
+	// 目录只受排除规则影响，以便继续遍历
+	if isDir || len(f.includes) == 0 {
+		return Decision{Included: true, Rule: negated}
+	}
+
+	r := lastMatch(f.includes, path, isDir, true)
+	if r == nil {
+		return Decision{Included: false}
+	}
+	return Decision{Included: !r.Negate, Rule: r}
+}
+
+// lastMatch 返回最后一条匹配路径的规则
+func lastMatch(rules []Rule, path string, isDir, withParents bool) *Rule {
+	for i := len(rules) - 1; i >= 0; i-- {
+		r := &rules[i]
+		if withParents && r.matchWithParents(path, isDir) || !withParents && r.match(path, isDir) {
+			return r
+		}
+	}
+	return nil
+}
+
+// parentDir 返回以 / 分隔的路径的父目录，顶层返回空字符串
+func parentDir(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return ""
+	}
+	return path[:i]
+}
+
+// SyncIgnoreFile 源目录中按目录生效的忽略文件
+const SyncIgnoreFile = ".gitsyncignore"
+
+// loadIgnoreRules 读取目录中的忽略文件，返回以该目录为基准的排除规则
+// dir 为目录的绝对路径，base 为其相对源目录的路径（以 / 分隔）
+func loadIgnoreRules(dir, base string, names []string) ([]Rule, error) {
+	var rules []Rule
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		source := name
+		if base != "" {
+			source = base + "/" + name
+		}
+		lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+		parsed := ParseRules(source, lines)
+		for i := range parsed {
+			parsed[i].Base = base
+		}
+		rules = append(rules, parsed...)
+	}
+	return rules, nil
+}
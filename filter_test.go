@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFilterMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		includes []string
+		excludes []string
+		path     string
+		isDir    bool
+		want     bool
+		rule     string
+	}{
+		{
+			name:     "Floating include matches any depth",
+			includes: []string{"*.md"},
+			path:     "guide/intro.md",
+			want:     true,
+			rule:     "*.md",
+		},
+		{
+			name:     "Anchored include only matches from root",
+			includes: []string{"/intro.md"},
+			path:     "guide/intro.md",
+			want:     false,
+		},
+		{
+			name:     "Negated exclude re-includes file",
+			excludes: []string{"*.log", "!keep.log"},
+			path:     "logs/keep.log",
+			want:     true,
+		},
+		{
+			name:     "Last matching exclude wins",
+			excludes: []string{"!keep.log", "*.log"},
+			path:     "keep.log",
+			want:     false,
+			rule:     "*.log",
+		},
+		{
+			name:     "Directory-only rule excludes directory",
+			excludes: []string{"node_modules/"},
+			path:     "web/node_modules",
+			isDir:    true,
+			want:     false,
+			rule:     "node_modules/",
+		},
+		{
+			name:     "Directory-only rule ignores files",
+			excludes: []string{"build/"},
+			path:     "build",
+			want:     true,
+		},
+		{
+			name:     "File inside excluded directory cannot be re-included",
+			excludes: []string{"draft/", "!draft/keep.md"},
+			path:     "draft/keep.md",
+			want:     false,
+			rule:     "draft/",
+		},
+		{
+			name:     "Trailing /** matches contents but not the directory",
+			excludes: []string{"draft/**", "!draft/publish.md"},
+			path:     "draft/publish.md",
+			want:     true,
+			rule:     "!draft/publish.md",
+		},
+		{
+			name:     "Directory include covers nested files",
+			includes: []string{"images/"},
+			path:     "images/a/b.png",
+			want:     true,
+			rule:     "images/",
+		},
+		{
+			name:     "Negated include",
+			includes: []string{"*.md", "!README.md"},
+			path:     "README.md",
+			want:     false,
+			rule:     "!README.md",
+		},
+		{
+			name:     "Directories are traversed when includes do not match",
+			includes: []string{"*.md"},
+			path:     "src",
+			isDir:    true,
+			want:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewFilter(tt.includes, tt.excludes).Match(tt.path, tt.isDir)
+			if got.Included != tt.want {
+				t.Errorf("Match(%q) = %v, want %v (%s)", tt.path, got.Included, tt.want, got.Reason())
+			}
+			if tt.rule != "" && (got.Rule == nil || got.Rule.Text != tt.rule) {
+				t.Errorf("Match(%q) decided by %s, want rule %q", tt.path, got.Reason(), tt.rule)
+			}
+		})
+	}
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 同步结果的发布方式
+const (
+	PublishPush        = "push"         // 直接推送到 branch（默认）
+	PublishPullRequest = "pull_request" // 推送到单独的分支并创建拉取请求
+)
+
+// 支持的代码托管平台
+const (
+	ForgeGitHub = "github"
+	ForgeGitLab = "gitlab"
+	ForgeGitea  = "gitea"
+)
+
+// PullRequestBranchPrefix 拉取请求分支的前缀，完整分支名为 git-syncer/<job>/<时间戳>
+const PullRequestBranchPrefix = "git-syncer/"
+
+// ForgeConfig 定义创建拉取请求使用的代码托管平台
+type ForgeConfig struct {
+	Type   string `yaml:"type"`    // github、gitlab 或 gitea，默认根据 remote_url 的主机名判断
+	APIURL string `yaml:"api_url"` // API 地址，默认根据 remote_url 推断
+	Token  string `yaml:"token"`   // API 令牌，默认使用用户的 git_password
+	Repo   string `yaml:"repo"`    // 仓库路径（owner/name），默认从 remote_url 解析
+	Title  string `yaml:"title"`   // 拉取请求标题
+}
+
+// PullRequest 代码托管平台上的拉取请求
+type PullRequest struct {
+	Number int    // 编号（GitLab 为 iid）
+	URL    string // 网页地址
+	Head   string // 源分支
+}
+
+// Forge 代码托管平台的拉取请求接口
+type Forge interface {
+	// FindPullRequest 查找合并到 base、源分支以 prefix 开头的打开状态的拉取请求，不存在时返回 nil
+	FindPullRequest(prefix, base string) (*PullRequest, error)
+	// CreatePullRequest 创建从 head 合并到 base 的拉取请求
+	CreatePullRequest(head, base, title, body string) (*PullRequest, error)
+	// UpdatePullRequest 更新拉取请求的标题和描述
+	UpdatePullRequest(pr *PullRequest, title, body string) error
+}
+
+// publish 返回任务的发布方式
+func (j *Job) publish() string {
+	if j.Publish == "" {
+		return PublishPush
+	}
+	return strings.ToLower(j.Publish)
+}
+
+// pullRequestPrefix 返回任务拉取请求分支的前缀
+func (j *Job) pullRequestPrefix() string {
+	return PullRequestBranchPrefix + sanitizePath(j.Name) + "/"
+}
+
+// newForge 根据任务配置创建代码托管平台客户端
+func newForge(user *User, job *Job) (Forge, error) {
+	cfg := job.Forge
+	host, repo := parseRemoteRepo(job.RemoteURL)
+	if cfg.Repo != "" {
+		repo = strings.Trim(cfg.Repo, "/")
+	}
+	if repo == "" {
+		return nil, fmt.Errorf("cannot determine repository from remote_url, set forge.repo")
+	}
+
+	forgeType := strings.ToLower(cfg.Type)
+	if forgeType == "" {
+		switch {
+		case host == "github.com":
+			forgeType = ForgeGitHub
+		case strings.Contains(host, "gitlab"):
+			forgeType = ForgeGitLab
+		case strings.Contains(host, "gitea"), host == "codeberg.org":
+			forgeType = ForgeGitea
+		default:
+			return nil, fmt.Errorf("cannot determine forge type for host %q, set forge.type", host)
+		}
+	}
+
+	apiURL := strings.TrimRight(cfg.APIURL, "/")
+	if apiURL == "" && host == "" {
+		return nil, fmt.Errorf("cannot determine forge API URL from remote_url, set forge.api_url")
+	}
+
+	token := cfg.Token
+	if token == "" {
+		token = user.GitPassword
+	}
+	if token == "" {
+		return nil, fmt.Errorf("forge token is required, set forge.token or git_password")
+	}
+
+	switch forgeType {
+	case ForgeGitHub:
+		if apiURL == "" {
+			apiURL = "https://" + host + "/api/v3"
+			if host == "github.com" {
+				apiURL = "https://api.github.com"
+			}
+		}
+		return &githubForge{client: newForgeClient(apiURL, map[string]string{
+			"Authorization": "Bearer " + token,
+			"Accept":        "application/vnd.github+json",
+		}), repo: repo}, nil
+	case ForgeGitLab:
+		if apiURL == "" {
+			apiURL = "https://" + host + "/api/v4"
+		}
+		return &gitlabForge{client: newForgeClient(apiURL, map[string]string{
+			"PRIVATE-TOKEN": token,
+		}), project: url.PathEscape(repo)}, nil
+	case ForgeGitea:
+		if apiURL == "" {
+			apiURL = "https://" + host + "/api/v1"
+		}
+		return &giteaForge{client: newForgeClient(apiURL, map[string]string{
+			"Authorization": "token " + token,
+		}), repo: repo}, nil
+	default:
+		return nil, fmt.Errorf("unsupported forge type: %s", cfg.Type)
+	}
+}
+
+// pullRequestState 一次同步中拉取请求的发布状态
+type pullRequestState struct {
+	forge    Forge
+	existing *PullRequest // 任务已打开的拉取请求，复用其分支
+	head     string       // 推送的分支
+	target   string       // 本次提交的起点
+}
+
+// preparePullRequest 在同步文件前将仓库重置到拉取请求分支或目标分支的最新提交
+// 本次提交只包含同步产生的变化，不会带上其他人在目标分支上的修改
+func (gs *GitSync) preparePullRequest(user *User, job *Job) (*pullRequestState, error) {
+	if job.RemoteURL == "" {
+		return nil, fmt.Errorf("publish: %s requires remote_url", PublishPullRequest)
+	}
+	forge, err := newForge(user, job)
+	if err != nil {
+		return nil, err
+	}
+
+	repoPath := job.GetRepoPath()
+	if err := gs.fetchBranch(user, repoPath, job.Branch); err != nil {
+		return nil, err
+	}
+
+	state := &pullRequestState{forge: forge}
+	state.existing, err = forge.FindPullRequest(job.pullRequestPrefix(), job.Branch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find open pull request: %v", err)
+	}
+	if state.existing != nil {
+		state.head = state.existing.Head
+		if err := gs.fetchBranch(user, repoPath, state.head); err != nil {
+			return nil, err
+		}
+		state.target = "refs/remotes/origin/" + state.head
+		gs.logger.Printf("DEBUG: Reusing pull request #%d (%s) for job: %s\n", state.existing.Number, state.head, job.Name)
+	} else {
+		state.head = job.pullRequestPrefix() + time.Now().Format("20060102-150405")
+		state.target = "refs/remotes/origin/" + job.Branch
+	}
+
+	before, _ := gitCommand(user, repoPath, "rev-parse", "HEAD").Output()
+	reset := gitCommand(user, repoPath, "reset", "--hard", state.target)
+	if output, err := reset.CombinedOutput(); err != nil {
+		return nil, newGitError("git reset "+state.target, output, err)
+	}
+	after, _ := gitCommand(user, repoPath, "rev-parse", "HEAD").Output()
+
+	// 仓库内容已变化，索引中缓存的文件状态不再可信
+	if !bytes.Equal(before, after) {
+		index, err := loadIndex(job)
+		if err != nil {
+			return nil, err
+		}
+		index.rehash()
+		if err := index.save(job); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// publishPullRequest 推送本次提交并创建或更新拉取请求
+func (gs *GitSync) publishPullRequest(user *User, job *Job, state *pullRequestState, report *SyncReport) error {
+	repoPath := job.GetRepoPath()
+
+	output, err := gitCommand(user, repoPath, "rev-list", "--count", state.target+"..HEAD").Output()
+	if err != nil {
+		return fmt.Errorf("failed to count new commits: %v", err)
+	}
+	if strings.TrimSpace(string(output)) == "0" {
+		gs.logger.Printf("DEBUG: No changes to publish for job: %s\n", job.Name)
+		return nil
+	}
+
+	gs.logger.Printf("DEBUG: Pushing to pull request branch: %s\n", state.head)
+	pushCmd := gitCommand(user, repoPath, "push", "origin", "HEAD:refs/heads/"+state.head)
+	if output, err := pushCmd.CombinedOutput(); err != nil {
+		gs.logger.Printf("ERROR: Push failed: %s\n", string(output))
+		return newGitError("git push", output, err)
+	}
+
+	title := job.Forge.Title
+	if title == "" {
+		title = fmt.Sprintf("Sync update from git-syncer: %s", job.Name)
+	}
+	body := pullRequestBody(job, report)
+
+	if state.existing != nil {
+		if err := state.forge.UpdatePullRequest(state.existing, title, body); err != nil {
+			return fmt.Errorf("failed to update pull request #%d: %v", state.existing.Number, err)
+		}
+		gs.logger.Printf("Updated pull request #%d for job %s: %s\n", state.existing.Number, job.Name, state.existing.URL)
+		return nil
+	}
+
+	pr, err := state.forge.CreatePullRequest(state.head, job.Branch, title, body)
+	if err != nil {
+		return fmt.Errorf("failed to create pull request: %v", err)
+	}
+	gs.logger.Printf("Opened pull request #%d for job %s: %s\n", pr.Number, job.Name, pr.URL)
+	return nil
+}
+
+// fetchBranch 获取远程分支并更新对应的远程跟踪分支
+// 单分支克隆的仓库默认只跟踪 branch，因此显式指定 refspec
+func (gs *GitSync) fetchBranch(user *User, repoPath, branch string) error {
+	refspec := fmt.Sprintf("+refs/heads/%s:refs/remotes/origin/%s", branch, branch)
+	if output, err := gitCommand(user, repoPath, "fetch", "origin", refspec).CombinedOutput(); err != nil {
+		return newGitError("git fetch "+branch, output, err)
+	}
+	return nil
+}
+
+// pullRequestBody 生成拉取请求的描述，列出最近一次同步的变化
+func pullRequestBody(job *Job, report *SyncReport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Automated sync of job `%s` by git-syncer.\n\nLast updated: %s\n", job.Name, time.Now().Format(time.RFC3339))
+	if report == nil {
+		return b.String()
+	}
+
+	section := func(title string, files []string) {
+		if len(files) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n%s:\n", title)
+		for _, file := range files {
+			fmt.Fprintf(&b, "- `%s`\n", file)
+		}
+	}
+	var renamed []string
+	for _, r := range report.Renamed {
+		renamed = append(renamed, r.From+"` → `"+r.To)
+	}
+	section("Updated", report.Copied)
+	section("Renamed", renamed)
+	section("Removed", report.Removed)
+	return b.String()
+}
+
+// parseRemoteRepo 从远程地址中解析主机名和仓库路径
+// 支持 https://host/owner/name.git、ssh://git@host/owner/name.git 和 git@host:owner/name.git
+func parseRemoteRepo(remoteURL string) (host, repo string) {
+	var path string
+	if strings.Contains(remoteURL, "://") {
+		u, err := url.Parse(remoteURL)
+		if err != nil {
+			return "", ""
+		}
+		host, path = u.Hostname(), u.Path
+	} else if i := strings.Index(remoteURL, ":"); i > 0 {
+		host, path = remoteURL[:i], remoteURL[i+1:]
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+	}
+	return host, strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+}
+
+// 查找拉取请求时的分页参数。Gitea 默认每页最多返回 50 条；源分支名带有时间戳，无法按分支在服务端过滤
+const (
+	forgePageSize = 50
+	forgeMaxPages = 100
+)
+
+// forgeClient 代码托管平台 REST API 的简单客户端
+type forgeClient struct {
+	baseURL string
+	headers map[string]string
+	http    *http.Client
+}
+
+func newForgeClient(baseURL string, headers map[string]string) *forgeClient {
+	return &forgeClient{
+		baseURL: baseURL,
+		headers: headers,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// do 发送 JSON 请求并解析响应，in 或 out 为 nil 时忽略
+func (c *forgeClient) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	for key, value := range c.headers {
+		req.Header.Set(key, value)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s returned status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("failed to decode response of %s %s: %v", method, path, err)
+		}
+	}
+	return nil
+}
+
+// pullResponse GitHub 和 Gitea 的拉取请求响应
+type pullResponse struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+	Head    struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+func (p pullResponse) pullRequest() *PullRequest {
+	return &PullRequest{Number: p.Number, URL: p.HTMLURL, Head: p.Head.Ref}
+}
+
+// findPull 在拉取请求列表中查找匹配的项
+func findPull(pulls []pullResponse, prefix, base string) *PullRequest {
+	for _, p := range pulls {
+		if p.Base.Ref == base && strings.HasPrefix(p.Head.Ref, prefix) {
+			return p.pullRequest()
+		}
+	}
+	return nil
+}
+
+// githubForge GitHub 和 GitHub Enterprise
+type githubForge struct {
+	client *forgeClient
+	repo   string
+}
+
+func (f *githubForge) FindPullRequest(prefix, base string) (*PullRequest, error) {
+	for page := 1; page <= forgeMaxPages; page++ {
+		var pulls []pullResponse
+		path := fmt.Sprintf("/repos/%s/pulls?state=open&base=%s&per_page=%d&page=%d", f.repo, url.QueryEscape(base), forgePageSize, page)
+		if err := f.client.do(http.MethodGet, path, nil, &pulls); err != nil {
+			return nil, err
+		}
+		if pr := findPull(pulls, prefix, base); pr != nil || len(pulls) < forgePageSize {
+			return pr, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *githubForge) CreatePullRequest(head, base, title, body string) (*PullRequest, error) {
+	var pull pullResponse
+	in := map[string]string{"head": head, "base": base, "title": title, "body": body}
+	if err := f.client.do(http.MethodPost, "/repos/"+f.repo+"/pulls", in, &pull); err != nil {
+		return nil, err
+	}
+	return pull.pullRequest(), nil
+}
+
+func (f *githubForge) UpdatePullRequest(pr *PullRequest, title, body string) error {
+	in := map[string]string{"title": title, "body": body}
+	return f.client.do(http.MethodPatch, fmt.Sprintf("/repos/%s/pulls/%d", f.repo, pr.Number), in, nil)
+}
+
+// giteaForge Gitea 和 Forgejo
+type giteaForge struct {
+	client *forgeClient
+	repo   string
+}
+
+func (f *giteaForge) FindPullRequest(prefix, base string) (*PullRequest, error) {
+	for page := 1; page <= forgeMaxPages; page++ {
+		var pulls []pullResponse
+		path := fmt.Sprintf("/repos/%s/pulls?state=open&limit=%d&page=%d", f.repo, forgePageSize, page)
+		if err := f.client.do(http.MethodGet, path, nil, &pulls); err != nil {
+			return nil, err
+		}
+		if pr := findPull(pulls, prefix, base); pr != nil || len(pulls) < forgePageSize {
+			return pr, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *giteaForge) CreatePullRequest(head, base, title, body string) (*PullRequest, error) {
+	var pull pullResponse
+	in := map[string]string{"head": head, "base": base, "title": title, "body": body}
+	if err := f.client.do(http.MethodPost, "/repos/"+f.repo+"/pulls", in, &pull); err != nil {
+		return nil, err
+	}
+	return pull.pullRequest(), nil
+}
+
+func (f *giteaForge) UpdatePullRequest(pr *PullRequest, title, body string) error {
+	in := map[string]string{"title": title, "body": body}
+	return f.client.do(http.MethodPatch, fmt.Sprintf("/repos/%s/pulls/%d", f.repo, pr.Number), in, nil)
+}
+
+// gitlabForge GitLab 合并请求
+type gitlabForge struct {
+	client  *forgeClient
+	project string // URL 编码后的项目路径
+}
+
+// mergeRequestResponse GitLab 的合并请求响应
+type mergeRequestResponse struct {
+	IID          int    `json:"iid"`
+	WebURL       string `json:"web_url"`
+	SourceBranch string `json:"source_branch"`
+}
+
+func (m mergeRequestResponse) pullRequest() *PullRequest {
+	return &PullRequest{Number: m.IID, URL: m.WebURL, Head: m.SourceBranch}
+}
+
+func (f *gitlabForge) FindPullRequest(prefix, base string) (*PullRequest, error) {
+	for page := 1; page <= forgeMaxPages; page++ {
+		var mrs []mergeRequestResponse
+		path := fmt.Sprintf("/projects/%s/merge_requests?state=opened&target_branch=%s&per_page=%d&page=%d", f.project, url.QueryEscape(base), forgePageSize, page)
+		if err := f.client.do(http.MethodGet, path, nil, &mrs); err != nil {
+			return nil, err
+		}
+		for _, mr := range mrs {
+			if strings.HasPrefix(mr.SourceBranch, prefix) {
+				return mr.pullRequest(), nil
+			}
+		}
+		if len(mrs) < forgePageSize {
+			break
+		}
+	}
+	return nil, nil
+}
+
+func (f *gitlabForge) CreatePullRequest(head, base, title, body string) (*PullRequest, error) {
+	var mr mergeRequestResponse
+	in := map[string]string{"source_branch": head, "target_branch": base, "title": title, "description": body}
+	if err := f.client.do(http.MethodPost, "/projects/"+f.project+"/merge_requests", in, &mr); err != nil {
+		return nil, err
+	}
+	return mr.pullRequest(), nil
+}
+
+func (f *gitlabForge) UpdatePullRequest(pr *PullRequest, title, body string) error {
+	in := map[string]string{"title": title, "description": body}
+	return f.client.do(http.MethodPut, fmt.Sprintf("/projects/%s/merge_requests/%d", f.project, pr.Number), in, nil)
+}
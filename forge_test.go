@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeGitHub 模拟 GitHub 拉取请求 API
+type fakeGitHub struct {
+	mu      sync.Mutex
+	pulls   []map[string]interface{}
+	updates int
+	auth    []string
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/user/docs/pulls":
+		json.NewEncoder(w).Encode(f.pulls)
+	case r.Method == http.MethodPost && r.URL.Path == "/repos/user/docs/pulls":
+		var in map[string]string
+		json.NewDecoder(r.Body).Decode(&in)
+		number := len(f.pulls) + 1
+		pull := map[string]interface{}{
+			"number":   number,
+			"html_url": fmt.Sprintf("https://github.com/user/docs/pull/%d", number),
+			"head":     map[string]string{"ref": in["head"]},
+			"base":     map[string]string{"ref": in["base"]},
+			"title":    in["title"],
+		}
+		f.pulls = append(f.pulls, pull)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(pull)
+	case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/repos/user/docs/pulls/"):
+		f.updates++
+		w.Write([]byte("{}"))
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func TestPullRequestPublish(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	api := &fakeGitHub{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	user := &User{Username: "Sync Bot", Email: "bot@example.com"}
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		output, err := gitCommand(user, dir, args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+
+	// 受保护的远程库
+	remote := filepath.Join(t.TempDir(), "remote.git")
+	work := t.TempDir()
+	git(work, "init", "-b", "main")
+	git(work, "commit", "--allow-empty", "-m", "base")
+	git(work, "clone", "--bare", work, remote)
+
+	gs := &GitSync{logger: createTestLogger()}
+	job := &Job{
+		Name:      "test-pull-request",
+		RemoteURL: "file://" + filepath.ToSlash(remote),
+		Publish:   PublishPullRequest,
+		Forge:     ForgeConfig{Type: ForgeGitHub, APIURL: server.URL, Repo: "user/docs", Token: "ghp_test"},
+	}
+	repoPath := job.GetRepoPath()
+	defer os.RemoveAll(repoPath)
+	defer os.Remove(indexPath(job))
+
+	run := func(content string) {
+		t.Helper()
+		if err := gs.initRepo(user, job); err != nil {
+			t.Fatalf("initRepo failed: %v", err)
+		}
+		state, err := gs.preparePullRequest(user, job)
+		if err != nil {
+			t.Fatalf("preparePullRequest failed: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(repoPath, "a.md"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		report := &SyncReport{Copied: []string{"a.md"}}
+		if _, err := gs.commitChanges(user, job, report); err != nil {
+			t.Fatalf("commitChanges failed: %v", err)
+		}
+		if err := gs.publishPullRequest(user, job, state, report); err != nil {
+			t.Fatalf("publishPullRequest failed: %v", err)
+		}
+	}
+
+	run("first")
+	if len(api.pulls) != 1 {
+		t.Fatalf("Expected 1 pull request, got %d", len(api.pulls))
+	}
+	head := api.pulls[0]["head"].(map[string]string)["ref"]
+	if !strings.HasPrefix(head, "git-syncer/test-pull-request/") {
+		t.Errorf("Unexpected pull request branch %q", head)
+	}
+	if got := git(remote, "log", "-1", "--format=%s", "main"); got != "base" {
+		t.Errorf("Expected main to be untouched, got commit %q", got)
+	}
+	if got := git(remote, "show", head+":a.md"); got != "first" {
+		t.Errorf("Expected pull request branch to contain the change, got %q", got)
+	}
+
+	// 第二次同步复用已打开的拉取请求
+	run("second")
+	if len(api.pulls) != 1 || api.updates != 1 {
+		t.Errorf("Expected the open pull request to be updated, got %d pulls and %d updates", len(api.pulls), api.updates)
+	}
+	if got := git(remote, "show", head+":a.md"); got != "second" {
+		t.Errorf("Expected pull request branch to be updated, got %q", got)
+	}
+	if got := git(remote, "rev-list", "--count", "main.."+head); got != "2" {
+		t.Errorf("Expected 2 commits on the pull request branch, got %s", got)
+	}
+	for _, auth := range api.auth {
+		if auth != "Bearer ghp_test" {
+			t.Errorf("Unexpected Authorization header %q", auth)
+		}
+	}
+}
+
+func TestForgeRequests(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := r.Method + " " + r.URL.EscapedPath()
+		if page := r.URL.Query().Get("page"); page != "" && r.Method == http.MethodGet {
+			request += "?page=" + page
+		}
+		requests = append(requests, request)
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/merge_requests") && r.Method == http.MethodGet:
+			w.Write([]byte(`[{"iid": 7, "web_url": "https://gitlab.example.com/mr/7", "source_branch": "git-syncer/docs/1"}]`))
+		case strings.HasSuffix(r.URL.Path, "/pulls") && r.Method == http.MethodGet:
+			// 第一页全部是其他分支的拉取请求，匹配项在第二页
+			if r.URL.Query().Get("page") == "1" {
+				pulls := make([]map[string]interface{}, forgePageSize)
+				for i := range pulls {
+					pulls[i] = map[string]interface{}{"number": i + 10, "head": map[string]string{"ref": "feature"}, "base": map[string]string{"ref": "main"}}
+				}
+				json.NewEncoder(w).Encode(pulls)
+				return
+			}
+			w.Write([]byte(`[{"number": 3, "head": {"ref": "git-syncer/docs/1"}, "base": {"ref": "main"}}]`))
+		case r.Method == http.MethodPost:
+			w.Write([]byte(`{"number": 4, "iid": 8}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	user := &User{GitPassword: "token"}
+
+	gitlab, err := newForge(user, &Job{RemoteURL: "git@gitlab.example.com:group/docs.git", Forge: ForgeConfig{APIURL: server.URL}})
+	if err != nil {
+		t.Fatalf("newForge failed: %v", err)
+	}
+	mr, err := gitlab.FindPullRequest("git-syncer/docs/", "main")
+	if err != nil || mr == nil || mr.Number != 7 {
+		t.Fatalf("Expected merge request !7, got %+v (%v)", mr, err)
+	}
+	if err := gitlab.UpdatePullRequest(mr, "title", "body"); err != nil {
+		t.Fatal(err)
+	}
+
+	gitea, err := newForge(user, &Job{RemoteURL: "https://gitea.example.com/org/docs.git", Forge: ForgeConfig{APIURL: server.URL}})
+	if err != nil {
+		t.Fatalf("newForge failed: %v", err)
+	}
+	if pr, err := gitea.FindPullRequest("git-syncer/docs/", "main"); err != nil || pr == nil || pr.Number != 3 {
+		t.Errorf("Expected pull request #3 on the second page, got %+v (%v)", pr, err)
+	}
+	if pr, err := gitea.FindPullRequest("git-syncer/notes/", "main"); err != nil || pr != nil {
+		t.Errorf("Expected no matching pull request, got %+v (%v)", pr, err)
+	}
+	if pr, err := gitea.CreatePullRequest("git-syncer/docs/1", "main", "title", "body"); err != nil || pr.Number != 4 {
+		t.Errorf("Expected pull request #4, got %+v (%v)", pr, err)
+	}
+
+	want := []string{
+		"GET /projects/group%2Fdocs/merge_requests?page=1",
+		"PUT /projects/group%2Fdocs/merge_requests/7",
+		"GET /repos/org/docs/pulls?page=1",
+		"GET /repos/org/docs/pulls?page=2",
+		"GET /repos/org/docs/pulls?page=1",
+		"GET /repos/org/docs/pulls?page=2",
+		"POST /repos/org/docs/pulls",
+	}
+	if strings.Join(requests, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected requests:\n%s\nwant:\n%s", strings.Join(requests, "\n"), strings.Join(want, "\n"))
+	}
+
+	if _, err := newForge(user, &Job{RemoteURL: "https://git.example.com/docs.git"}); err == nil {
+		t.Errorf("Expected an error for an unknown forge host")
+	}
+}
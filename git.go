@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gitCommand 创建在 dir 中执行的 git 命令
+// 用户的提交身份通过环境变量传入，只作用于本次命令，不会写入任何 git 配置文件
+func gitCommand(user *User, dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), user.gitEnv()...)
+	return cmd
+}
+
+// gitEnv 返回执行用户的 git 命令时使用的环境变量
+func (u *User) gitEnv() []string {
+	if u == nil {
+		return nil
+	}
+	env := []string{
+		"GIT_AUTHOR_NAME=" + u.Username,
+		"GIT_AUTHOR_EMAIL=" + u.Email,
+		"GIT_COMMITTER_NAME=" + u.Username,
+		"GIT_COMMITTER_EMAIL=" + u.Email,
+	}
+	if sshCommand := u.sshCommand(); sshCommand != "" {
+		env = append(env, "GIT_SSH_COMMAND="+sshCommand)
+	}
+	env = append(env, u.credentialEnv()...)
+
+	var config [][2]string
+	config = append(config, u.credentialConfig()...)
+	config = append(config, u.signingConfig()...)
+	return append(env, configEnv(config)...)
+}
+
+// configEnv 通过 GIT_CONFIG_COUNT/KEY/VALUE 环境变量传入 git 配置
+// 配置只作用于 git-syncer 自身的 git 命令，不会写入任何配置文件
+func configEnv(config [][2]string) []string {
+	if len(config) == 0 {
+		return nil
+	}
+	env := []string{fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(config))}
+	for i, kv := range config {
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, kv[0]),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, kv[1]))
+	}
+	return env
+}
+
+// sshCommand 返回用户专用的 GIT_SSH_COMMAND，未配置 SSH 选项时返回空字符串
+func (u *User) sshCommand() string {
+	if u.SshKeyPath == "" && u.SshKnownHosts == "" && u.SshStrictHostKeyChecking == "" {
+		return ""
+	}
+
+	// 后台运行时没有终端，禁止 ssh 交互式询问
+	args := []string{"ssh", "-o", "BatchMode=yes"}
+	if u.SshKeyPath != "" {
+		args = append(args, "-i", shellQuote(expandHome(u.SshKeyPath)), "-o", "IdentitiesOnly=yes")
+	}
+	if u.SshKnownHosts != "" {
+		args = append(args, "-o", shellQuote("UserKnownHostsFile="+expandHome(u.SshKnownHosts)))
+	}
+	if u.SshStrictHostKeyChecking != "" {
+		args = append(args, "-o", "StrictHostKeyChecking="+u.SshStrictHostKeyChecking)
+	}
+	return strings.Join(args, " ")
+}
+
+// shellQuote 为 GIT_SSH_COMMAND 中的参数加单引号，git 会通过 shell 解析该命令
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(filepath.ToSlash(s), "'", `'\''`) + "'"
+}
+
+// expandHome 将路径开头的 ~ 展开为当前用户的主目录
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, `~\`) {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
@@ -3,16 +3,17 @@ module github.com/bestK/git-syncer
 go 1.23.3
 
 require (
+	github.com/bmatcuk/doublestar/v4 v4.7.1
+	github.com/fsnotify/fsnotify v1.9.0
 	github.com/go-co-op/gocron v1.37.0
-	gopkg.in/yaml.v2 v2.4.0
+	github.com/robfig/cron/v3 v3.0.1
+	github.com/sevlyar/go-daemon v0.1.6
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/bmatcuk/doublestar/v4 v4.7.1 // indirect
 	github.com/google/uuid v1.4.0 // indirect
 	github.com/kardianos/osext v0.0.0-20190222173326-2bc1f35cddc0 // indirect
-	github.com/robfig/cron/v3 v3.0.1 // indirect
-	github.com/sevlyar/go-daemon v0.1.6 // indirect
 	go.uber.org/atomic v1.9.0 // indirect
 	golang.org/x/sys v0.27.0 // indirect
 )
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// HistoryFile 保存任务运行记录的文件，每行一条 JSON 记录
+const HistoryFile = "history.jsonl"
+
+// 运行记录的默认保留策略
+const (
+	DefaultHistoryMaxRecords = 1000 // 最多保留的记录数
+	DefaultHistoryMaxAgeDays = 90   // 记录最长保留天数
+)
+
+// historyLockTimeout 等待其他进程写完历史文件的最长时间
+const historyLockTimeout = 30 * time.Second
+
+// HistoryConfig 运行记录的保留策略，0 使用默认值，负数表示不限制
+type HistoryConfig struct {
+	MaxRecords int `yaml:"max_records,omitempty"`
+	MaxAgeDays int `yaml:"max_age_days,omitempty"`
+}
+
+// maxRecords 返回最多保留的记录数，0 表示不限制
+func (h HistoryConfig) maxRecords() int {
+	switch {
+	case h.MaxRecords == 0:
+		return DefaultHistoryMaxRecords
+	case h.MaxRecords < 0:
+		return 0
+	}
+	return h.MaxRecords
+}
+
+// maxAge 返回记录最长保留时间，0 表示不限制
+func (h HistoryConfig) maxAge() time.Duration {
+	days := h.MaxAgeDays
+	switch {
+	case days == 0:
+		days = DefaultHistoryMaxAgeDays
+	case days < 0:
+		return 0
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// RunRecord 一次任务运行的记录
+type RunRecord struct {
+	Job      string    `json:"job"`
+	User     string    `json:"user"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Status   string    `json:"status"`
+	Stage    string    `json:"stage,omitempty"`
+	Commit   string    `json:"commit,omitempty"`
+	Branch   string    `json:"branch,omitempty"`
+	Files    []string  `json:"files,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	ExitCode int       `json:"exit_code,omitempty"`
+	Stderr   string    `json:"stderr,omitempty"`
+}
+
+// historyPath 返回运行记录文件的路径
+func historyPath() string {
+	return filepath.Join(GitSyncerDir, HistoryFile)
+}
+
+// newRunRecord 根据同步结果创建运行记录，错误信息中的认证信息会被清除
+func newRunRecord(user *User, job *Job, start, end time.Time, result SyncResult, changes ChangeSummary, redactor *Redactor) RunRecord {
+	record := RunRecord{
+		Job:      job.Name,
+		User:     user.Username,
+		Start:    start,
+		End:      end,
+		Status:   result.Status(),
+		Stage:    result.Stage,
+		Commit:   changes.Commit,
+		Branch:   changes.Branch,
+		Files:    changes.Files(),
+		ExitCode: result.ExitCode,
+		Stderr:   redactor.Redact(result.Stderr),
+	}
+	if result.Err != nil {
+		record.Error = redactor.Redact(result.Err.Error())
+	}
+	return record
+}
+
+// recordRun 将运行记录追加到历史文件，并按保留策略清理旧记录
+func (gs *GitSync) recordRun(record RunRecord, retention HistoryConfig) error {
+	if gs.historyFile == "" {
+		return nil
+	}
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	// sync 子命令和后台进程可能同时写入，通过锁文件避免互相覆盖
+	release, err := acquireLock(gs.historyFile+".lock", historyLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	records, err := readHistory(gs.historyFile)
+	if err != nil {
+		return err
+	}
+	records = pruneHistory(append(records, record), retention, record.End)
+	return writeHistory(gs.historyFile, records)
+}
+
+// readHistory 读取历史文件中的全部记录，无法解析的行被忽略
+func readHistory(path string) ([]RunRecord, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %v", err)
+	}
+	defer f.Close()
+
+	var records []RunRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var record RunRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history file: %v", err)
+	}
+	return records, nil
+}
+
+// pruneHistory 删除超过保留时间和数量的旧记录
+func pruneHistory(records []RunRecord, retention HistoryConfig, now time.Time) []RunRecord {
+	if maxAge := retention.maxAge(); maxAge > 0 {
+		cutoff := now.Add(-maxAge)
+		kept := records[:0]
+		for _, record := range records {
+			if !record.End.Before(cutoff) {
+				kept = append(kept, record)
+			}
+		}
+		records = kept
+	}
+	if limit := retention.maxRecords(); limit > 0 && len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	return records
+}
+
+// writeHistory 通过临时文件原子地写入历史文件
+func writeHistory(path string, records []RunRecord) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), HistoryFile+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create history file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to encode run record: %v", err)
+		}
+		w.Write(append(data, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write history file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write history file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace history file: %v", err)
+	}
+	return nil
+}
+
+// HistoryQuery 运行记录的查询条件
+type HistoryQuery struct {
+	Job    string // 只返回该任务的记录
+	Failed bool   // 只返回失败的记录
+	Limit  int    // 最多返回最近的多少条记录，0 表示不限制
+}
+
+// queryHistory 按条件筛选记录，保持时间顺序
+func queryHistory(records []RunRecord, query HistoryQuery) []RunRecord {
+	var matched []RunRecord
+	for _, record := range records {
+		if query.Job != "" && record.Job != query.Job {
+			continue
+		}
+		if query.Failed && record.Status != "failure" {
+			continue
+		}
+		matched = append(matched, record)
+	}
+	if query.Limit > 0 && len(matched) > query.Limit {
+		matched = matched[len(matched)-query.Limit:]
+	}
+	return matched
+}
+
+// printHistory 以表格形式输出运行记录
+func printHistory(w io.Writer, records []RunRecord) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "START\tJOB\tUSER\tSTATUS\tSTAGE\tDURATION\tCOMMIT\tFILES\tERROR")
+	for _, r := range records {
+		commit := r.Commit
+		if len(commit) > 7 {
+			commit = commit[:7]
+		}
+		stage := r.Stage
+		if stage == "" {
+			stage = "-"
+		}
+		if commit == "" {
+			commit = "-"
+		}
+		// 错误信息只显示第一行
+		errText, _, _ := strings.Cut(r.Error, "\n")
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			r.Start.Local().Format("2006-01-02 15:04:05"), r.Job, r.User, r.Status, stage,
+			r.End.Sub(r.Start).Round(time.Millisecond), commit, len(r.Files), errText)
+	}
+	tw.Flush()
+}
+
+// runHistory 执行 history 子命令
+func runHistory(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	var query HistoryQuery
+	var asJSON bool
+	fs.StringVar(&query.Job, "job", "", "Only show runs of this job")
+	fs.BoolVar(&query.Failed, "failed", false, "Only show failed runs")
+	fs.IntVar(&query.Limit, "n", 20, "Number of most recent runs to show (0 for all)")
+	fs.BoolVar(&asJSON, "json", false, "Print runs as JSON lines")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	records, err := readHistory(historyPath())
+	if err != nil {
+		return err
+	}
+	records = queryHistory(records, query)
+
+	if asJSON {
+		enc := json.NewEncoder(stdout)
+		for _, record := range records {
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(records) == 0 {
+		fmt.Fprintln(stdout, "No runs recorded")
+		return nil
+	}
+	printHistory(stdout, records)
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHistoryRetentionAndQuery(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	var records []RunRecord
+	for i, job := range []string{"old", "docs", "notes", "docs", "docs"} {
+		end := now.Add(time.Duration(i-4) * time.Hour)
+		if job == "old" {
+			end = now.AddDate(0, 0, -100)
+		}
+		status := "success"
+		if i == 3 {
+			status = "failure"
+		}
+		records = append(records, RunRecord{Job: job, Start: end.Add(-time.Second), End: end, Status: status})
+	}
+
+	path := filepath.Join(t.TempDir(), HistoryFile)
+	if err := writeHistory(path, records); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(records) {
+		t.Fatalf("Expected %d records, got %d", len(records), len(read))
+	}
+
+	// 默认保留 90 天，超过保留天数的记录被删除
+	kept := pruneHistory(append([]RunRecord{}, read...), HistoryConfig{}, now)
+	if len(kept) != 4 || kept[0].Job != "docs" {
+		t.Fatalf("Expected the old record to be pruned, got %+v", kept)
+	}
+	if kept := pruneHistory(append([]RunRecord{}, read...), HistoryConfig{MaxRecords: 2, MaxAgeDays: -1}, now); len(kept) != 2 || !kept[1].End.Equal(now) {
+		t.Errorf("Expected the 2 most recent records, got %+v", kept)
+	}
+
+	jobs := func(records []RunRecord) []string {
+		var names []string
+		for _, r := range records {
+			names = append(names, r.Job+":"+r.Status)
+		}
+		return names
+	}
+	tests := []struct {
+		query HistoryQuery
+		want  []string
+	}{
+		{HistoryQuery{}, []string{"docs:success", "notes:success", "docs:failure", "docs:success"}},
+		{HistoryQuery{Job: "notes"}, []string{"notes:success"}},
+		{HistoryQuery{Failed: true}, []string{"docs:failure"}},
+		{HistoryQuery{Job: "docs", Limit: 2}, []string{"docs:failure", "docs:success"}},
+	}
+	for _, tt := range tests {
+		if got := jobs(queryHistory(kept, tt.query)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("queryHistory(%+v) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestRecordRunConcurrentWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), HistoryFile)
+
+	// 遗留的过期锁文件不会阻塞写入
+	stale := path + ".lock"
+	if err := os.WriteFile(stale, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// 每个 GitSync 有各自的互斥锁，相当于不同的进程
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			gs := &GitSync{historyFile: path}
+			now := time.Now()
+			record := RunRecord{Job: fmt.Sprintf("job%d", i), Start: now, End: now, Status: "success"}
+			if err := gs.recordRun(record, HistoryConfig{}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	records, err := readHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 10 {
+		t.Errorf("Expected 10 records, got %d", len(records))
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("Expected the lock file to be removed, got %v", err)
+	}
+}
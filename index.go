@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// IndexDir 保存任务文件索引的目录
+const IndexDir = ".index"
+
+// IndexEntry 记录任务写入仓库的单个文件
+type IndexEntry struct {
+	Source  string `json:"source"`   // 源文件路径
+	Size    int64  `json:"size"`     // 源文件大小
+	ModTime int64  `json:"mod_time"` // 源文件修改时间（Unix 纳秒）
+	Mode    uint32 `json:"mode"`     // 源文件权限
+	Hash    string `json:"hash"`     // 文件内容的 SHA-256
+
+	DestSize    int64 `json:"dest_size"`     // 仓库中文件的大小
+	DestModTime int64 `json:"dest_mod_time"` // 仓库中文件的修改时间（Unix 纳秒）
+}
+
+// newIndexEntry 根据源文件、内容哈希和写入后的仓库文件创建索引项
+func newIndexEntry(file sourceFile, hash, destPath string) IndexEntry {
+	entry := IndexEntry{
+		Source:  file.relPath,
+		Size:    file.info.Size(),
+		ModTime: file.info.ModTime().UnixNano(),
+		Mode:    uint32(file.info.Mode().Perm()),
+		Hash:    hash,
+	}
+	if info, err := os.Lstat(destPath); err == nil {
+		entry.DestSize = info.Size()
+		entry.DestModTime = info.ModTime().UnixNano()
+	}
+	return entry
+}
+
+// unchanged 检查源文件的大小、修改时间、权限以及仓库中文件的大小和修改时间是否与索引一致
+// 仓库中的文件可能被 rebase、merge 或切换分支改写，这时需要重新比较内容
+func (e IndexEntry) unchanged(info os.FileInfo, destPath string) bool {
+	if e.Hash == "" || e.Size != info.Size() || e.ModTime != info.ModTime().UnixNano() ||
+		e.Mode != uint32(info.Mode().Perm()) {
+		return false
+	}
+	dest, err := os.Lstat(destPath)
+	return err == nil && e.DestSize == dest.Size() && e.DestModTime == dest.ModTime().UnixNano()
+}
+
+// SyncIndex 记录任务拥有的仓库文件，键为仓库内以 / 分隔的相对路径
+type SyncIndex struct {
+	Files map[string]IndexEntry `json:"files"`
+}
+
+// indexPath 返回任务索引文件的路径
+func indexPath(job *Job) string {
+	return filepath.Join(GitSyncerDir, IndexDir, sanitizePath(job.Name)+".json")
+}
+
+// loadIndex 加载任务的文件索引，不存在时返回空索引
+func loadIndex(job *Job) (*SyncIndex, error) {
+	index := &SyncIndex{Files: make(map[string]IndexEntry)}
+
+	data, err := os.ReadFile(indexPath(job))
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %v", err)
+	}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("failed to parse index %s: %v", indexPath(job), err)
+	}
+	if index.Files == nil {
+		index.Files = make(map[string]IndexEntry)
+	}
+	return index, nil
+}
+
+// save 将索引写入磁盘
+func (idx *SyncIndex) save(job *Job) error {
+	path := indexPath(job)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create index directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode index: %v", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write index: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// rehash 清除缓存的文件状态，下次同步时重新计算全部哈希
+// 文件的归属关系保持不变
+func (idx *SyncIndex) rehash() {
+	for dest := range idx.Files {
+		idx.Files[dest] = IndexEntry{Source: idx.Files[dest].Source}
+	}
+}
+
+// hashFile 计算文件内容的 SHA-256
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// linkHash 计算符号链接的哈希，与链接目标相关
+func linkHash(target string) string {
+	sum := sha256.Sum256([]byte("symlink:" + target))
+	return hex.EncodeToString(sum[:])
+}
+
+// destHash 计算仓库中文件的哈希，符号链接本身不会被解引用
+func destHash(path string) (string, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		return linkHash(target), nil
+	}
+	return hashFile(path)
+}
+
+// fileHashEquals 检查文件是否存在且内容哈希与给定值一致
+func fileHashEquals(path, hash string) bool {
+	got, err := destHash(path)
+	return err == nil && got == hash
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 文件在仓库中的目录布局
+const (
+	LayoutFlat     = "flat"     // 平铺到 remote_path（默认）
+	LayoutWorkdir  = "workdir"  // 保持相对工作目录的结构，等同 keep_structure: true
+	LayoutRelative = "relative" // 保持相对源目录的结构，可放在 remote_path 下
+)
+
+// layout 返回任务的目录布局
+func (j *Job) layout() string {
+	switch {
+	case j.Layout != "":
+		return j.Layout
+	case j.KeepStructure:
+		return LayoutWorkdir
+	default:
+		return LayoutFlat
+	}
+}
+
+// 平铺目录时文件名冲突的处理方式
+const (
+	CollisionFail   = "fail"   // 任务失败并列出冲突的源文件（默认）
+	CollisionPrefix = "prefix" // 用父目录作为文件名前缀
+	CollisionHash   = "hash"   // 在文件名后追加源路径的哈希
+)
+
+// onCollision 返回平铺目录时文件名冲突的处理方式
+func (j *Job) onCollision() string {
+	if j.OnCollision == "" {
+		return CollisionFail
+	}
+	return j.OnCollision
+}
+
+// Collision 记录映射到同一目标路径的多个源文件
+type Collision struct {
+	Dest    string   // 仓库内的目标路径
+	Sources []string // 冲突的源文件（相对源目录）
+}
+
+func (c Collision) String() string {
+	return fmt.Sprintf("%s <- %s", c.Dest, strings.Join(c.Sources, ", "))
+}
+
+// destPath 返回源文件在仓库中的相对路径（以 / 分隔）
+// 源路径的 prefix 位于 remote_path 之下
+func (j *Job) destPath(file sourceFile) string {
+	base := path.Join(strings.Trim(filepath.ToSlash(j.RemotePath), "/"), strings.Trim(filepath.ToSlash(file.prefix), "/"))
+	switch j.layout() {
+	case LayoutWorkdir:
+		return path.Join(base, file.relPath)
+	case LayoutRelative:
+		return path.Join(base, file.srcRel)
+	default:
+		return path.Join(base, filepath.Base(file.path))
+	}
+}
+
+// planDestinations 计算每个源文件的目标路径，并按 on_collision 处理冲突
+func (gs *GitSync) planDestinations(job *Job, files []sourceFile, report *SyncReport) (map[string]sourceFile, error) {
+	groups := make(map[string][]sourceFile)
+	for _, file := range files {
+		dest := job.destPath(file)
+		groups[dest] = append(groups[dest], file)
+	}
+
+	desired := make(map[string]sourceFile, len(files))
+	var renamed []sourceFile
+	for _, dest := range sortedKeys(groups) {
+		group := groups[dest]
+		if len(group) == 1 {
+			desired[dest] = group[0]
+			continue
+		}
+
+		collision := Collision{Dest: dest}
+		crossSource := false
+		for _, file := range group {
+			collision.Sources = append(collision.Sources, file.sourceName(job))
+			crossSource = crossSource || file.source != group[0].source
+		}
+		sort.Strings(collision.Sources)
+		report.Collisions = append(report.Collisions, collision)
+		gs.logger.Printf("WARNING: [%s] file name collision: %s\n", job.Name, collision)
+
+		// 不同源路径映射到同一目标路径时无法自动处理
+		if crossSource {
+			return nil, fmt.Errorf("multiple sources map to the same destination: %s", collision)
+		}
+		renamed = append(renamed, group...)
+	}
+
+	if len(report.Collisions) == 0 {
+		return desired, nil
+	}
+
+	strategy := job.onCollision()
+	if strategy != CollisionPrefix && strategy != CollisionHash {
+		if strategy != CollisionFail {
+			return nil, fmt.Errorf("invalid on_collision strategy: %s", job.OnCollision)
+		}
+		return nil, fmt.Errorf("%d file name collision(s) with keep_structure disabled: %s",
+			len(report.Collisions), joinCollisions(report.Collisions))
+	}
+
+	// 冲突的文件按策略改名，改名后仍冲突则失败
+	for _, file := range renamed {
+		dest := collisionName(job.destPath(file), file.srcRel, strategy)
+		if other, exists := desired[dest]; exists {
+			return nil, fmt.Errorf("file name collision cannot be resolved with %s strategy: %s and %s both map to %s",
+				strategy, other.srcRel, file.srcRel, dest)
+		}
+		desired[dest] = file
+		gs.logger.Printf("DEBUG: [%s] resolved collision: %s -> %s\n", job.Name, file.srcRel, dest)
+	}
+
+	return desired, nil
+}
+
+// sourceName 返回文件的可读来源，配置了多个源路径时带上源路径
+func (f sourceFile) sourceName(job *Job) string {
+	if len(job.Sources) > 1 {
+		return job.Sources[f.source].Path + ":" + f.srcRel
+	}
+	return f.srcRel
+}
+
+// collisionName 按策略为冲突的文件生成新的目标路径
+func collisionName(dest, srcRel, strategy string) string {
+	dir, base := path.Split(dest)
+	if strategy == CollisionPrefix {
+		if parent := parentDir(srcRel); parent != "" {
+			return dir + strings.ReplaceAll(parent, "/", "-") + "-" + base
+		}
+		return dest
+	}
+
+	sum := sha256.Sum256([]byte(srcRel))
+	ext := path.Ext(base)
+	return dir + strings.TrimSuffix(base, ext) + "-" + hex.EncodeToString(sum[:4]) + ext
+}
+
+// joinCollisions 将冲突列表格式化为单行文本
+func joinCollisions(collisions []Collision) string {
+	parts := make([]string, len(collisions))
+	for i, c := range collisions {
+		parts[i] = c.String()
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPlanDestinationsCollisions(t *testing.T) {
+	files := []sourceFile{
+		{path: "/src/a/config.yaml", srcRel: "a/config.yaml"},
+		{path: "/src/b/config.yaml", srcRel: "b/config.yaml"},
+		{path: "/src/readme.md", srcRel: "readme.md"},
+	}
+
+	tests := []struct {
+		strategy string
+		wantErr  bool
+		want     []string
+	}{
+		{strategy: "", wantErr: true},
+		{strategy: CollisionFail, wantErr: true},
+		{strategy: CollisionPrefix, want: []string{"a-config.yaml", "b-config.yaml", "readme.md"}},
+		{strategy: CollisionHash, want: []string{"config-d4a82b18.yaml", "config-f34a83d0.yaml", "readme.md"}},
+	}
+
+	gs := &GitSync{logger: createTestLogger()}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			report := &SyncReport{}
+			job := &Job{OnCollision: tt.strategy}
+			desired, err := gs.planDestinations(job, files, report)
+
+			if len(report.Collisions) != 1 || len(report.Collisions[0].Sources) != 2 {
+				t.Errorf("Expected one collision with two sources, got %v", report.Collisions)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Expected collision error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("planDestinations failed: %v", err)
+			}
+
+			got := sortedKeys(desired)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected destinations %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Expected destinations %v, got %v", tt.want, got)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestJobDestPath(t *testing.T) {
+	file := sourceFile{
+		path:    "/work/data/reports/2024/q1.csv",
+		relPath: "data/reports/2024/q1.csv",
+		srcRel:  "2024/q1.csv",
+	}
+
+	tests := []struct {
+		name string
+		job  Job
+		want string
+	}{
+		{name: "Flat", job: Job{}, want: "q1.csv"},
+		{name: "Flat under remote_path", job: Job{RemotePath: "out"}, want: "out/q1.csv"},
+		{name: "Keep structure", job: Job{KeepStructure: true}, want: "data/reports/2024/q1.csv"},
+		{name: "Relative", job: Job{Layout: LayoutRelative}, want: "2024/q1.csv"},
+		{name: "Relative under remote_path", job: Job{Layout: LayoutRelative, RemotePath: "/reports/"}, want: "reports/2024/q1.csv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.job.destPath(file); got != tt.want {
+				t.Errorf("destPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// LockDir 保存任务锁文件的目录
+const LockDir = ".locks"
+
+// jobLockTimeout 等待其他进程执行完同一任务的最长时间
+const jobLockTimeout = 10 * time.Minute
+
+// lockRetryInterval 等待锁文件时的重试间隔
+const lockRetryInterval = 100 * time.Millisecond
+
+// lockStaleAge 锁文件中没有有效 PID 时，超过该时间视为过期（创建者在写入 PID 前退出）
+const lockStaleAge = 10 * time.Second
+
+// acquireLock 以 O_EXCL 方式创建锁文件并写入当前进程的 PID，用于在多个 git-syncer 进程之间互斥
+// 持有锁的进程已退出时锁文件被视为过期并删除；最多等待 wait，返回的 release 删除锁文件
+func acquireLock(path string, wait time.Duration) (release func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.WriteString(strconv.Itoa(os.Getpid()))
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("failed to write lock file %s: %v", path, err)
+			}
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create lock file %s: %v", path, err)
+		}
+
+		if lockStale(path) {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			if pid, err := readPidFile(path); err == nil {
+				return nil, fmt.Errorf("lock file %s is held by process %d", path, pid)
+			}
+			return nil, fmt.Errorf("lock file %s is held by another process", path)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// lockStale 检查锁文件的持有者是否已经退出
+func lockStale(path string) bool {
+	pid, err := readPidFile(path)
+	if err == nil {
+		return !processRunning(pid)
+	}
+	info, statErr := os.Stat(path)
+	if statErr != nil {
+		// 锁文件已被删除，可以立即重试
+		return os.IsNotExist(statErr)
+	}
+	return time.Since(info.ModTime()) > lockStaleAge
+}
+
+// jobLockPath 返回任务锁文件的路径，sync 子命令和后台进程通过它避免在同一仓库中同时执行 git
+func jobLockPath(job *Job) string {
+	return filepath.Join(GitSyncerDir, LockDir, sanitizePath(job.Name)+".lock")
+}
@@ -1,22 +1,26 @@
 package main
 
 import (
-	"flag"
+	"bytes"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"runtime"
-
 	"github.com/bmatcuk/doublestar/v4"
 	"github.com/go-co-op/gocron"
 	"github.com/sevlyar/go-daemon"
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
 var (
@@ -28,8 +32,12 @@ var (
 
 // 在 package main 声明后添加常量定义
 const (
-	GitSyncerDir = ".git-syncer" // 同步仓库的基础目录
-	Banner       = `
+	GitSyncerDir   = ".git-syncer"           // 同步仓库的基础目录
+	TempFilePrefix = ".git-syncer-tmp-"      // 复制文件时使用的临时文件前缀
+	PidFile        = "git-syncer.pid"        // 后台进程的 pid 文件
+	LogFile        = "git_sync.log"          // 日志文件
+	DaemonLogFile  = "git-syncer-daemon.log" // 后台进程的标准输出和错误输出
+	Banner         = `
 	______ _ _      _____                            
    / ____/(_) /_   / ___/__  ______  ________  _____
   / / __/ / __/   \__ \/ / / / __ \/ ___/ _ \/ ___/
@@ -43,6 +51,12 @@ const (
 type Config struct {
 	Users    []User          `yaml:"users"`
 	Webhooks []WebhookConfig `yaml:"webhooks"`
+	History  HistoryConfig   `yaml:"history,omitempty"` // 运行记录的保留策略
+
+	// secrets 从 ${env:...}、${file:...}、${cmd:...} 引用中解析出的值，需要从日志中清除
+	secrets []string
+	// lines 每个字段在配置文件中的行号，键为字段的 YAML 路径
+	lines map[string]int
 }
 
 // User 定义用户配置
@@ -53,26 +67,71 @@ type User struct {
 	Jobs        []Job  `yaml:"jobs"`
 	GitUsername string `yaml:"git_username,omitempty"`
 	GitPassword string `yaml:"git_password,omitempty"`
+	// SSH 传输配置，仅作用于该用户任务的 git 命令
+	SshKnownHosts            string `yaml:"ssh_known_hosts,omitempty"`              // known_hosts 文件路径
+	SshStrictHostKeyChecking string `yaml:"ssh_strict_host_key_checking,omitempty"` // yes、no 或 accept-new
+	// 提交签名配置，仅作用于 git-syncer 执行的 git 命令
+	SigningKey    string `yaml:"signing_key,omitempty"`    // GPG 密钥 ID，或 SSH 密钥路径（支持 ~）
+	SigningFormat string `yaml:"signing_format,omitempty"` // openpgp（默认）或 ssh
 }
 
 // Job 定义单个同步任务的配置
 type Job struct {
-	Name          string   `yaml:"name"`
-	Schedule      string   `yaml:"schedule"`
-	SourcePath    string   `yaml:"source_path"`
-	RemoteURL     string   `yaml:"remote_url"`
-	Includes      []string `yaml:"includes"`
-	Excludes      []string `yaml:"excludes"`
-	Webhooks      []string `yaml:"webhooks"`
-	Branch        string   `yaml:"branch"`
-	MergeStrategy string   `yaml:"merge_strategy"` // 新增：合并策略配置
-	RemotePath    string   `yaml:"remote_path"`    // 远程仓库中的目标路径
-	KeepStructure bool     `yaml:"keep_structure"` // 是否保持原目录结构
+	Name           string      `yaml:"name"`
+	Schedule       string      `yaml:"schedule"`
+	SourcePath     string      `yaml:"source_path"`
+	RemoteURL      string      `yaml:"remote_url"`
+	Includes       []string    `yaml:"includes"`
+	Excludes       []string    `yaml:"excludes"`
+	Webhooks       []string    `yaml:"webhooks"`
+	Branch         string      `yaml:"branch"`
+	MergeStrategy  string      `yaml:"merge_strategy"`  // 新增：合并策略配置
+	OnConflict     string      `yaml:"on_conflict"`     // rebase/merge 冲突的处理方式：fail（默认）、ours 或 theirs，仅作用于任务拥有的文件
+	PushRetries    int         `yaml:"push_retries"`    // 推送因远程有新提交被拒绝时的重试次数（默认3）
+	Publish        string      `yaml:"publish"`         // 发布方式：push（默认）或 pull_request
+	Forge          ForgeConfig `yaml:"forge"`           // pull_request 模式使用的代码托管平台
+	CommitMessage  string      `yaml:"commit_message"`  // 提交信息模板（text/template），默认为 "Sync update by <用户>: <时间>"
+	CommitTrailers []string    `yaml:"commit_trailers"` // 追加在提交信息末尾的 trailer 模板，例如 "Co-authored-by: ..."
+	Signoff        bool        `yaml:"signoff"`         // 是否添加 Signed-off-by trailer
+	OnCollision    string      `yaml:"on_collision"`    // 平铺目录时文件名冲突的处理方式：fail（默认）、prefix 或 hash
+	RemotePath     string      `yaml:"remote_path"`     // 远程仓库中的目标路径
+	KeepStructure  bool        `yaml:"keep_structure"`  // 是否保持原目录结构
+	Layout         string      `yaml:"layout"`          // 目录布局：flat、workdir（同 keep_structure）或 relative
+	Mirror         bool        `yaml:"mirror"`          // 镜像模式：删除源中已不存在的文件
+	UseGitignore   bool        `yaml:"use_gitignore"`   // 是否同时遵循源目录中的 .gitignore
+	Trigger        string      `yaml:"trigger"`         // 触发方式：cron（默认）或 watch
+	Debounce       int         `yaml:"debounce"`        // 监听模式的静默期（秒）
+	PreserveTimes  bool        `yaml:"preserve_times"`  // 是否保留源文件的修改时间
+	Symlinks       string      `yaml:"symlinks"`        // 符号链接策略：follow（默认）、preserve 或 skip
+	Sources        []Source    `yaml:"sources"`         // 多个源路径，与 source_path 二选一
+}
+
+// Source 定义任务的一个源路径
+type Source struct {
+	Path     string   `yaml:"path"`
+	Includes []string `yaml:"includes"` // 追加在任务级 includes 之后
+	Excludes []string `yaml:"excludes"` // 追加在任务级 excludes 之后
+	Prefix   string   `yaml:"prefix"`   // 在仓库中的目标前缀，位于 remote_path 之下
+}
+
+// GetSources 返回任务的全部源路径，未配置 sources 时使用 source_path
+func (j *Job) GetSources() []Source {
+	if len(j.Sources) == 0 {
+		return []Source{{Path: j.SourcePath, Includes: j.Includes, Excludes: j.Excludes}}
+	}
+
+	sources := make([]Source, len(j.Sources))
+	for i, src := range j.Sources {
+		src.Includes = append(append([]string{}, j.Includes...), src.Includes...)
+		src.Excludes = append(append([]string{}, j.Excludes...), src.Excludes...)
+		sources[i] = src
+	}
+	return sources
 }
 
 // 添加一个获取仓库路径的辅助方法
 func (j *Job) GetRepoPath() string {
-	return filepath.Join(".git-syncer", j.Name)
+	return filepath.Join(GitSyncerDir, sanitizePath(j.Name))
 }
 
 // GitSync 同步器结构
@@ -80,19 +139,26 @@ type GitSync struct {
 	config         *Config
 	scheduler      *gocron.Scheduler
 	logger         *log.Logger
+	redactor       *Redactor
 	webhookManager *WebhookManager
+	historyFile    string // 运行记录文件，为空时不保存运行记录
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex // 每个任务一把锁，防止定时和监听同时触发
 }
 
 // NewGitSync 创建新的同步器实例
 func NewGitSync(configPath string) (*GitSync, error) {
 	// 创建日志记录器
-	logFile, err := os.OpenFile("git_sync.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	logFile, err := os.OpenFile(LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open log file: %v", err)
 	}
 
+	// 所有日志在写入前清除认证信息
+	redactor := NewRedactor()
 	multiWriter := io.MultiWriter(os.Stdout, logFile)
-	logger := log.New(multiWriter, "", log.LstdFlags)
+	logger := log.New(redactor.Writer(multiWriter), "", log.LstdFlags)
 
 	// 加载配置
 	config, err := loadConfig(configPath)
@@ -104,12 +170,25 @@ func NewGitSync(configPath string) (*GitSync, error) {
 	scheduler := gocron.NewScheduler(time.Local)
 
 	webhookManager := NewWebhookManager(logger)
+	webhookManager.redactor = redactor
 
 	gs := &GitSync{
 		config:         config,
 		scheduler:      scheduler,
 		logger:         logger,
+		redactor:       redactor,
 		webhookManager: webhookManager,
+		historyFile:    historyPath(),
+	}
+
+	redactor.Add(config.secrets...)
+	for _, user := range config.Users {
+		redactor.Add(user.GitPassword)
+		for _, job := range user.Jobs {
+			_, _, password := splitCredentials(job.RemoteURL)
+			redactor.Add(password)
+			redactor.Add(job.Forge.Token)
+		}
 	}
 
 	// 注册全局 webhook
@@ -119,24 +198,6 @@ func NewGitSync(configPath string) (*GitSync, error) {
 		}
 	}
 
-	// 初始化所有用户的仓库
-	for _, user := range config.Users {
-		// 设置用户的Git配置
-		if err := gs.setupUserGitConfig(&user); err != nil {
-			logger.Printf("Failed to setup git config for user %s: %v\n", user.Username, err)
-			continue
-		}
-
-		// 初始化每个任务的仓库
-		for _, job := range user.Jobs {
-			if err := gs.initRepo(&user, &job); err != nil {
-				logger.Printf("Failed to initialize repository for job %s: %v\n", job.Name, err)
-				continue
-			}
-			logger.Printf("Successfully initialized repository for job: %s\n", job.Name)
-		}
-	}
-
 	return gs, nil
 }
 
@@ -147,10 +208,30 @@ func loadConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
+	// 记录每个字段的行号，用于报告配置错误
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	// 未知的字段视为错误，避免拼写错误的配置被忽略
 	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && err != io.EOF {
 		return nil, err
 	}
+	config.lines = make(map[string]int)
+	configLines(&root, "", config.lines)
+
+	secrets, err := resolveReferences(&config)
+	if err != nil {
+		if configErr, ok := err.(*ConfigError); ok {
+			configErr.Line = config.line(configErr.Path)
+		}
+		return nil, err
+	}
+	config.secrets = secrets
 
 	return &config, nil
 }
@@ -159,8 +240,11 @@ func loadConfig(path string) (*Config, error) {
 func (gs *GitSync) Run() error {
 	gs.logger.Println("Starting Git sync service...")
 
+	// 配置有误的任务不会启动，其他任务不受影响
+	configErrs := validateConfig(gs.config)
+
 	// 为每个用户设置任务
-	for _, user := range gs.config.Users {
+	for i, user := range gs.config.Users {
 		// 设置用户的Git配置
 		if err := gs.setupUserGitConfig(&user); err != nil {
 			gs.logger.Printf("Failed to setup git config for user %s: %v\n", user.Username, err)
@@ -168,12 +252,34 @@ func (gs *GitSync) Run() error {
 		}
 
 		// 设置用户的所有任务
-		for _, job := range user.Jobs {
+		for j, job := range user.Jobs {
+			if !gs.checkJobConfig(configErrs, fmt.Sprintf("users[%d].jobs[%d]", i, j), &job) {
+				continue
+			}
+
+			// 初始化任务的仓库
+			if err := gs.initJobRepo(&user, &job); err != nil {
+				gs.logger.Printf("Failed to initialize repository for job %s: %v\n", job.Name, err)
+			} else {
+				gs.logger.Printf("Successfully initialized repository for job: %s\n", job.Name)
+			}
+
 			// 创建任务的闭包以保留user和job变量
 			userCopy := user
 			jobCopy := job
+
+			// 监听模式下 schedule 可选，作为兜底的定时同步
+			if job.Trigger == TriggerWatch {
+				if err := gs.watchJob(&userCopy, &jobCopy); err != nil {
+					gs.logger.Printf("Failed to watch job %s for user %s: %v\n", job.Name, user.Username, err)
+				}
+				if job.Schedule == "" {
+					continue
+				}
+			}
+
 			_, err := gs.scheduler.Cron(job.Schedule).Do(func() {
-				gs.syncJob(&userCopy, &jobCopy)
+				gs.runJob(&userCopy, &jobCopy)
 			})
 			if err != nil {
 				gs.logger.Printf("Failed to schedule job %s for user %s: %v\n", job.Name, user.Username, err)
@@ -183,43 +289,132 @@ func (gs *GitSync) Run() error {
 		}
 	}
 
+	// 收到停止信号时停止调度器，等待正在执行的任务完成后退出
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		sig, ok := <-signals
+		if !ok {
+			return
+		}
+		gs.logger.Printf("Received %s, stopping Git sync service...\n", sig)
+		gs.scheduler.Stop()
+	}()
+
 	// 启动调度器
 	gs.scheduler.StartBlocking()
+	gs.logger.Println("Git sync service stopped")
+	return nil
+}
+
+// RehashIndexes 清除所有任务索引中缓存的文件状态，下次同步时重新计算哈希
+func (gs *GitSync) RehashIndexes() error {
+	for _, user := range gs.config.Users {
+		for _, job := range user.Jobs {
+			index, err := loadIndex(&job)
+			if err != nil {
+				return fmt.Errorf("job %s: %v", job.Name, err)
+			}
+			index.rehash()
+			if err := index.save(&job); err != nil {
+				return fmt.Errorf("job %s: %v", job.Name, err)
+			}
+			gs.logger.Printf("Cleared file index for job: %s\n", job.Name)
+		}
+	}
 	return nil
 }
 
-// setupUserGitConfig 设置用户的Git配置
+// setupUserGitConfig 检查用户的Git配置
+// 提交身份只在执行 git 命令时通过环境变量传入，不会修改全局配置
 func (gs *GitSync) setupUserGitConfig(user *User) error {
-	// 设置全局Git配置
-	commands := []struct {
-		name  string
-		args  []string
-		fatal bool
-	}{
-		{"git", []string{"config", "--global", "user.name", user.Username}, true},
-		{"git", []string{"config", "--global", "user.email", user.Email}, true},
+	if err := validateUser(user); err != nil {
+		return err
 	}
 
 	// 如果提供了SSH密钥，确保其权限正确
 	if user.SshKeyPath != "" {
-		if err := os.Chmod(user.SshKeyPath, 0600); err != nil {
+		if err := os.Chmod(expandHome(user.SshKeyPath), 0600); err != nil {
 			return fmt.Errorf("failed to set SSH key permissions: %v", err)
 		}
 	}
 
-	// 执行Git配置命令
-	for _, cmd := range commands {
-		command := exec.Command(cmd.name, cmd.args...)
-		if err := command.Run(); err != nil && cmd.fatal {
-			return fmt.Errorf("failed to execute git command %v: %v", cmd.args, err)
-		}
+	return nil
+}
+
+// validateUser 验证用户配置
+func validateUser(user *User) error {
+	if user.Username == "" {
+		return fieldErrorf("username", "username and email are required")
+	}
+	if user.Email == "" {
+		return fieldErrorf("email", "username and email are required")
+	}
+
+	switch user.SshStrictHostKeyChecking {
+	case "", "yes", "no", "accept-new":
+	default:
+		return fieldErrorf("ssh_strict_host_key_checking", "invalid ssh_strict_host_key_checking: %s", user.SshStrictHostKeyChecking)
+	}
+
+	// 启动时确认签名密钥可用，避免每次提交才失败
+	if err := user.checkSigningKey(); err != nil {
+		return &FieldError{Field: "signing_key", Err: err}
 	}
 
 	return nil
 }
 
-// syncJob 执行单个同步任务
-func (gs *GitSync) syncJob(user *User, job *Job) {
+// runJob 执行同步任务，同一任务的多次触发依次执行
+func (gs *GitSync) runJob(user *User, job *Job) SyncResult {
+	lock := gs.jobLock(job.Name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// 其他进程可能正在同一仓库中执行该任务
+	release, err := acquireLock(jobLockPath(job), jobLockTimeout)
+	if err != nil {
+		gs.logger.Printf("ERROR: Sync job %s skipped: %v\n", job.Name, err)
+		var result SyncResult
+		result.fail(StageInit, err)
+		return result
+	}
+	defer release()
+
+	return gs.syncJob(user, job)
+}
+
+// initJobRepo 持有任务锁文件时初始化任务的仓库
+func (gs *GitSync) initJobRepo(user *User, job *Job) error {
+	release, err := acquireLock(jobLockPath(job), jobLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return gs.initRepo(user.withURLCredentials(job.RemoteURL), job)
+}
+
+// jobLock 返回任务对应的互斥锁
+func (gs *GitSync) jobLock(name string) *sync.Mutex {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	if gs.locks == nil {
+		gs.locks = make(map[string]*sync.Mutex)
+	}
+	lock, ok := gs.locks[name]
+	if !ok {
+		lock = &sync.Mutex{}
+		gs.locks[name] = lock
+	}
+	return lock
+}
+
+// syncJob 执行单个同步任务并返回同步结果
+func (gs *GitSync) syncJob(user *User, job *Job) (result SyncResult) {
+	user = user.withURLCredentials(job.RemoteURL)
 	startTime := time.Now()
 	ctx := WebhookContext{
 		User:      *user,
@@ -227,17 +422,22 @@ func (gs *GitSync) syncJob(user *User, job *Job) {
 		StartTime: startTime.Format(time.RFC3339),
 	}
 
-	var syncErr error
 	defer func() {
 		endTime := time.Now()
 		ctx.EndTime = endTime.Format(time.RFC3339)
 		ctx.Duration = endTime.Sub(startTime).String()
+		ctx.Status = result.Status()
+		ctx.Error = result.Err
+		ctx.Result = result
 
-		if syncErr != nil {
-			ctx.Status = "failure"
-			ctx.Error = syncErr
-		} else {
-			ctx.Status = "success"
+		if result.Err != nil {
+			gs.logger.Printf("ERROR: Sync job %s failed at stage %s (exit code %d): %v\n", job.Name, result.Stage, result.ExitCode, result.Err)
+		}
+
+		// 保存运行记录
+		record := newRunRecord(user, job, startTime, endTime, result, ctx.Changes, gs.redactor)
+		if err := gs.recordRun(record, gs.config.History); err != nil {
+			gs.logger.Printf("WARNING: Failed to record run history: %v\n", err)
 		}
 
 		// 执行任务的 webhook
@@ -253,23 +453,61 @@ func (gs *GitSync) syncJob(user *User, job *Job) {
 
 	// 确保目标仓库存在并配置
 	if err := gs.initRepo(user, job); err != nil {
-		gs.logger.Printf("Failed to init repository for job %s: %v\n", job.Name, err)
+		result.fail(StageInit, err)
 		return
 	}
 
+	// 拉取请求模式下，先将仓库重置到拉取请求分支或目标分支
+	var pullRequest *pullRequestState
+	if job.publish() == PublishPullRequest {
+		state, err := gs.preparePullRequest(user, job)
+		if err != nil {
+			result.fail(StagePrepare, err)
+			return
+		}
+		pullRequest = state
+	}
+
 	// 同步文件
-	if err := gs.syncFiles(job); err != nil {
-		gs.logger.Printf("Failed to sync files for job %s: %v\n", job.Name, err)
+	report, err := gs.syncFiles(job)
+	if report != nil {
+		ctx.Collisions = report.Collisions
+	}
+	if err != nil {
+		result.fail(StageSync, err)
 		return
 	}
 
 	// 提交更改
-	if err := gs.commitChanges(user, job); err != nil {
-		gs.logger.Printf("Failed to commit changes for job %s: %v\n", job.Name, err)
+	changes, err := gs.commitChanges(user, job, report)
+	if changes != nil {
+		branch := job.Branch
+		if pullRequest != nil {
+			branch = pullRequest.head
+		}
+		ctx.ChangedFiles = changes.Files()
+		ctx.Changes = changeSummary(user, job, changes, branch)
+		gs.logger.Printf("Changes for job %s: %s\n", job.Name, ctx.Changes)
+	}
+	if err != nil {
+		// 已有提交时失败发生在推送阶段
+		if changes != nil {
+			result.fail(StagePush, err)
+		} else {
+			result.fail(StageCommit, err)
+		}
 		return
 	}
 
+	if pullRequest != nil {
+		if err := gs.publishPullRequest(user, job, pullRequest, report); err != nil {
+			result.fail(StagePublish, err)
+			return
+		}
+	}
+
 	gs.logger.Printf("Completed sync job: %s for user: %s\n", job.Name, user.Username)
+	return result
 }
 
 // initRepo 初始化检查Git仓库
@@ -283,7 +521,7 @@ func (gs *GitSync) initRepo(user *User, job *Job) error {
 	// 在当前目录下创建 .git-syncer/job-name 目录
 	repoDir := filepath.Join(currentDir, GitSyncerDir, sanitizePath(job.Name))
 
-	gs.logger.Printf("DEBUG: Initializing repo - Path: %s, RemoteURL: %s\n", repoDir, job.RemoteURL)
+	gs.logger.Printf("DEBUG: Initializing repo - Path: %s, RemoteURL: %s\n", repoDir, redactURL(job.RemoteURL))
 
 	// 如果未指定分支，使用默认分支
 	if job.Branch == "" {
@@ -291,218 +529,613 @@ func (gs *GitSync) initRepo(user *User, job *Job) error {
 	}
 	gs.logger.Printf("DEBUG: Using branch: %s\n", job.Branch)
 
-	isNewRepo := false
 	if _, err := os.Stat(filepath.Join(repoDir, ".git")); os.IsNotExist(err) {
 		gs.logger.Printf("DEBUG: Initializing new repository at %s\n", repoDir)
 
-		// 创建目录
-		if err := os.MkdirAll(repoDir, 0755); err != nil {
-			return fmt.Errorf("failed to create directory: %v", err)
+		// 远程分支已存在时克隆，使本地提交建立在已有历史之上
+		cloned := false
+		if job.RemoteURL != "" {
+			if cloned, err = gs.cloneRepo(user, repoDir, job); err != nil {
+				return err
+			}
 		}
 
-		// 初始化新仓库
-		cmd := exec.Command("git", "init")
-		cmd.Dir = repoDir
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("git init failed: %v", err)
-		}
+		if !cloned {
+			// 创建目录
+			if err := os.MkdirAll(repoDir, 0755); err != nil {
+				return fmt.Errorf("failed to create directory: %v", err)
+			}
 
-		isNewRepo = true
+			// 初始化新仓库
+			cmd := gitCommand(user, repoDir, "init")
+			if output, err := cmd.CombinedOutput(); err != nil {
+				return newGitError("git init", output, err)
+			}
+		}
 	} else {
 		gs.logger.Printf("DEBUG: Using existing repository at %s\n", repoDir)
 	}
 
-	// 如果配置了远程库
-	if job.RemoteURL != "" {
-		remoteURL := job.RemoteURL
-		if user.GitUsername != "" && user.GitPassword != "" {
-			remoteURL = addCredentialsToURL(job.RemoteURL, user.GitUsername, user.GitPassword)
+	// 复制文件时产生的临时文件不应被提交
+	if err := ensureGitExclude(repoDir, TempFilePrefix+"*"); err != nil {
+		gs.logger.Printf("WARNING: Failed to update git exclude file: %v\n", err)
+	}
+
+	// 配置变化后同步 origin、分支和稀疏检出的目录
+	if err := gs.reconcileRemote(user, repoDir, job); err != nil {
+		return err
+	}
+	if err := gs.reconcileBranch(user, repoDir, job); err != nil {
+		return err
+	}
+	if err := gs.reconcileSparse(user, repoDir, job); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// cloneRepo 浅克隆远程库中的任务分支，设置了 remote_path 时只检出该目录
+// 远程库中没有该分支时返回 false，由调用方初始化空仓库
+func (gs *GitSync) cloneRepo(user *User, repoDir string, job *Job) (bool, error) {
+	remoteURL, _, _ := splitCredentials(job.RemoteURL)
+	parentDir := filepath.Dir(repoDir)
+	if err := os.MkdirAll(parentDir, 0755); err != nil {
+		return false, fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	lsRemote := gitCommand(user, parentDir, "ls-remote", "--exit-code", "--heads", remoteURL, job.Branch)
+	if output, err := lsRemote.CombinedOutput(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 2 {
+			gs.logger.Printf("DEBUG: Branch %s does not exist on remote, starting with an empty repository\n", job.Branch)
+			return false, nil
 		}
+		return false, newGitError("git ls-remote", output, err)
+	}
 
-		// 检查是否已有 origin
-		checkRemote := exec.Command("git", "remote", "get-url", "origin")
-		checkRemote.Dir = repoDir
-		if err := checkRemote.Run(); err != nil {
-			// 如果没有 origin，添加它
-			cmd := exec.Command("git", "remote", "add", "origin", remoteURL)
-			cmd.Dir = repoDir
-			if err := cmd.Run(); err != nil {
-				return fmt.Errorf("failed to add remote: %v", err)
-			}
+	args := []string{"clone", "--depth", "1", "--single-branch", "--no-tags", "--branch", job.Branch}
+	sparsePath := job.sparsePath()
+	if sparsePath != "" {
+		// 文件只会写入 remote_path，其余目录无需下载和检出
+		args = append(args, "--filter=blob:none", "--sparse")
+	}
+	args = append(args, remoteURL, repoDir)
+
+	// 克隆失败时只清理本次创建的目录
+	cleanup := func() {}
+	if _, err := os.Stat(repoDir); os.IsNotExist(err) {
+		cleanup = func() { os.RemoveAll(repoDir) }
+	}
+
+	clone := gitCommand(user, parentDir, args...)
+	if output, err := clone.CombinedOutput(); err != nil {
+		cleanup()
+		return false, newGitError("git clone", output, err)
+	}
+
+	if sparsePath != "" {
+		cmd := gitCommand(user, repoDir, "sparse-checkout", "set", sparsePath)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			cleanup()
+			return false, newGitError("git sparse-checkout", output, err)
+		}
+		gs.logger.Printf("Cloned branch %s of %s for job %s (sparse: %s)\n", job.Branch, remoteURL, job.Name, sparsePath)
+	} else {
+		gs.logger.Printf("Cloned branch %s of %s for job %s\n", job.Branch, remoteURL, job.Name)
+	}
+	return true, nil
+}
+
+// sparsePath 返回稀疏检出的目录，文件可能写入 remote_path 之外时返回空字符串
+func (j *Job) sparsePath() string {
+	if j.RemotePath == "" || j.layout() == LayoutWorkdir {
+		return ""
+	}
+	return strings.Trim(filepath.ToSlash(j.RemotePath), "/")
+}
+
+// reconcileSparse 使稀疏检出的目录与配置保持一致，不再适用稀疏检出时恢复完整检出
+// 否则文件会写入检出范围之外，git add 将失败
+func (gs *GitSync) reconcileSparse(user *User, repoDir string, job *Job) error {
+	output, _ := gitCommand(user, repoDir, "config", "--bool", "--get", "core.sparseCheckout").Output()
+	if strings.TrimSpace(string(output)) != "true" {
+		return nil
+	}
+
+	sparsePath := job.sparsePath()
+	if sparsePath == "" {
+		cmd := gitCommand(user, repoDir, "sparse-checkout", "disable")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return newGitError("git sparse-checkout disable", output, err)
+		}
+		gs.logger.Printf("Reconciled sparse checkout for job %s: disabled\n", job.Name)
+		return nil
+	}
+
+	current := ""
+	if output, err := gitCommand(user, repoDir, "sparse-checkout", "list").Output(); err == nil {
+		current = strings.TrimSpace(string(output))
+	}
+	if current == sparsePath {
+		return nil
+	}
+	cmd := gitCommand(user, repoDir, "sparse-checkout", "set", sparsePath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return newGitError("git sparse-checkout set", output, err)
+	}
+	gs.logger.Printf("Reconciled sparse checkout for job %s: %s -> %s\n", job.Name, current, sparsePath)
+	return nil
+}
+
+// reconcileRemote 使 origin 与配置中的 remote_url 保持一致
+func (gs *GitSync) reconcileRemote(user *User, repoDir string, job *Job) error {
+	// 认证信息通过 GIT_ASKPASS 提供，origin 中只保存不含认证信息的 URL
+	configured, _, _ := splitCredentials(job.RemoteURL)
+
+	// 读取原始配置值，get-url 会应用 insteadOf 改写
+	existing := ""
+	if output, err := gitCommand(user, repoDir, "config", "--get", "remote.origin.url").Output(); err == nil {
+		existing = strings.TrimSpace(string(output))
+	}
+
+	switch {
+	case configured == existing:
+		gs.logger.Printf("DEBUG: Origin is up to date for job: %s\n", job.Name)
+		return nil
+	case configured == "":
+		cmd := gitCommand(user, repoDir, "remote", "remove", "origin")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return newGitError("git remote remove", output, err)
+		}
+		gs.logger.Printf("Reconciled origin for job %s: removed %s (remote_url is no longer configured)\n", job.Name, redactURL(existing))
+	case existing == "":
+		cmd := gitCommand(user, repoDir, "remote", "add", "origin", configured)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return newGitError("git remote add", output, err)
+		}
+		gs.logger.Printf("Reconciled origin for job %s: added %s\n", job.Name, configured)
+	default:
+		cmd := gitCommand(user, repoDir, "remote", "set-url", "origin", configured)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return newGitError("git remote set-url", output, err)
 		}
+		gs.logger.Printf("Reconciled origin for job %s: %s -> %s\n", job.Name, redactURL(existing), configured)
+	}
+	return nil
+}
 
-		if isNewRepo {
-			// 设置分支
-			cmd := exec.Command("git", "branch", "-M", job.Branch)
-			cmd.Dir = repoDir
-			if err := cmd.Run(); err != nil {
-				return fmt.Errorf("failed to set branch %s: %v", job.Branch, err)
+// reconcileBranch 切换到配置的分支，并将其上游设置为 origin 上的同名分支
+func (gs *GitSync) reconcileBranch(user *User, repoDir string, job *Job) error {
+	current := ""
+	if output, err := gitCommand(user, repoDir, "symbolic-ref", "--short", "HEAD").Output(); err == nil {
+		current = strings.TrimSpace(string(output))
+	}
+
+	if current != job.Branch {
+		if err := gs.switchBranch(user, repoDir, job); err != nil {
+			return err
+		}
+		if current == "" {
+			current = "detached HEAD"
+		}
+		gs.logger.Printf("Reconciled branch for job %s: %s -> %s\n", job.Name, current, job.Branch)
+	}
+
+	// 未配置远程库时 origin 已被移除，git 会同时清除分支的上游配置
+	if job.RemoteURL == "" {
+		return nil
+	}
+
+	upstream := map[string]string{
+		"branch." + job.Branch + ".remote": "origin",
+		"branch." + job.Branch + ".merge":  "refs/heads/" + job.Branch,
+	}
+	changed := false
+	for _, key := range sortedKeys(upstream) {
+		output, _ := gitCommand(user, repoDir, "config", "--get", key).Output()
+		if strings.TrimSpace(string(output)) == upstream[key] {
+			continue
+		}
+		cmd := gitCommand(user, repoDir, "config", key, upstream[key])
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return newGitError("git config "+key, output, err)
+		}
+		changed = true
+	}
+	if changed {
+		gs.logger.Printf("Reconciled tracking for job %s: %s -> origin/%s\n", job.Name, job.Branch, job.Branch)
+	}
+	return nil
+}
+
+// switchBranch 切换到 job.Branch，分支不存在时基于远程分支或当前提交创建
+func (gs *GitSync) switchBranch(user *User, repoDir string, job *Job) error {
+	ref := "refs/heads/" + job.Branch
+
+	// 尚无提交的仓库直接修改 HEAD 指向
+	if err := gitCommand(user, repoDir, "rev-parse", "--verify", "--quiet", "HEAD").Run(); err != nil {
+		cmd := gitCommand(user, repoDir, "symbolic-ref", "HEAD", ref)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return newGitError("git symbolic-ref", output, err)
+		}
+		return nil
+	}
+
+	var args []string
+	if err := gitCommand(user, repoDir, "rev-parse", "--verify", "--quiet", ref).Run(); err == nil {
+		args = []string{"checkout", job.Branch}
+	} else {
+		args = []string{"checkout", "-b", job.Branch}
+		if job.RemoteURL != "" {
+			// 远程已有该分支时以其为起点，避免推送时产生分叉
+			// 单分支克隆的 fetch 不会更新其他分支的远程跟踪引用，需要显式指定 refspec
+			if err := gs.fetchBranch(user, repoDir, job.Branch); err != nil {
+				gs.logger.Printf("WARNING: Failed to fetch branch %s: %v\n", job.Branch, err)
 			}
-		} else {
-			// 如果不是新仓库，确保在正确的分支上
-			// 先检查分支是否存在
-			checkBranch := exec.Command("git", "rev-parse", "--verify", job.Branch)
-			checkBranch.Dir = repoDir
-			if err := checkBranch.Run(); err != nil {
-				// 分支不存在，创建新分支
-				createBranch := exec.Command("git", "checkout", "-b", job.Branch)
-				createBranch.Dir = repoDir
-				if err := createBranch.Run(); err != nil {
-					return fmt.Errorf("failed to create branch %s: %v", job.Branch, err)
-				}
-			} else {
-				// 分支存在，切换到该分支
-				checkout := exec.Command("git", "checkout", job.Branch)
-				checkout.Dir = repoDir
-				if err := checkout.Run(); err != nil {
-					return fmt.Errorf("failed to checkout branch %s: %v", job.Branch, err)
-				}
+			remoteRef := "refs/remotes/origin/" + job.Branch
+			if err := gitCommand(user, repoDir, "rev-parse", "--verify", "--quiet", remoteRef).Run(); err == nil {
+				args = append(args, "origin/"+job.Branch)
 			}
 		}
 	}
 
+	cmd := gitCommand(user, repoDir, args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return newGitError("git checkout "+job.Branch, output, err)
+	}
 	return nil
 }
 
-// addCredentialsToURL 向Git URL添加认证信息
-func addCredentialsToURL(url, username, password string) string {
-	if strings.HasPrefix(url, "https://") {
-		return fmt.Sprintf("https://%s:%s@%s", username, password, strings.TrimPrefix(url, "https://"))
+// ensureGitExclude 确保仓库的 .git/info/exclude 中包含给定规则
+func ensureGitExclude(repoDir, pattern string) error {
+	excludePath := filepath.Join(repoDir, ".git", "info", "exclude")
+	data, err := os.ReadFile(excludePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == pattern {
+			return nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(excludePath), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(excludePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		pattern = "\n" + pattern
 	}
-	return url
+	_, err = f.WriteString(pattern + "\n")
+	return err
 }
 
 // validateJob 验证任务配置
 func (gs *GitSync) validateJob(job *Job) error {
 	if job.RemotePath != "" && job.KeepStructure {
-		return fmt.Errorf("remote_path and keep_structure cannot be used together")
+		return fieldErrorf("keep_structure", "remote_path and keep_structure cannot be used together, use layout: relative instead")
+	}
+	if job.SourcePath != "" && len(job.Sources) > 0 {
+		return fieldErrorf("sources", "source_path and sources cannot be used together")
+	}
+	for i, src := range job.GetSources() {
+		if strings.TrimSpace(src.Path) == "" {
+			if len(job.Sources) == 0 {
+				return fieldErrorf("source_path", "source_path or sources is required")
+			}
+			return fieldErrorf(fmt.Sprintf("sources[%d].path", i), "sources[%d]: path cannot be empty", i)
+		}
+	}
+	if job.KeepStructure && job.Layout != "" && job.Layout != LayoutWorkdir {
+		return fieldErrorf("layout", "keep_structure and layout: %s cannot be used together", job.Layout)
+	}
+	switch job.layout() {
+	case LayoutFlat, LayoutWorkdir, LayoutRelative:
+	default:
+		return fieldErrorf("layout", "invalid layout: %s", job.Layout)
+	}
+	if job.layout() == LayoutWorkdir && job.RemotePath != "" {
+		return fieldErrorf("remote_path", "remote_path cannot be used with layout: %s", LayoutWorkdir)
+	}
+	switch job.mergeStrategy() {
+	case MergeNormal, MergeRebase, MergeMerge, MergeForce:
+	default:
+		return fieldErrorf("merge_strategy", "invalid merge_strategy: %s", job.MergeStrategy)
+	}
+	switch job.onConflict() {
+	case ConflictFail, ConflictOurs, ConflictTheirs:
+	default:
+		return fieldErrorf("on_conflict", "invalid on_conflict: %s", job.OnConflict)
+	}
+	switch job.onCollision() {
+	case CollisionFail, CollisionPrefix, CollisionHash:
+	default:
+		return fieldErrorf("on_collision", "invalid on_collision: %s", job.OnCollision)
+	}
+	switch job.symlinkPolicy() {
+	case SymlinksFollow, SymlinksPreserve, SymlinksSkip:
+	default:
+		return fieldErrorf("symlinks", "invalid symlinks policy: %s", job.Symlinks)
+	}
+	if err := validateCommitTemplates(job); err != nil {
+		return &FieldError{Field: "commit_message", Err: err}
+	}
+	switch job.publish() {
+	case PublishPush:
+	case PublishPullRequest:
+		if job.RemoteURL == "" {
+			return fieldErrorf("remote_url", "publish: %s requires remote_url", PublishPullRequest)
+		}
+	default:
+		return fieldErrorf("publish", "invalid publish: %s", job.Publish)
 	}
 	return nil
 }
 
+// FileDecision 记录单个文件的过滤结果
+type FileDecision struct {
+	Source   string // 所属源路径
+	Path     string // 相对源目录的路径
+	Included bool
+	Reason   string // 决定结果的规则
+}
+
+// SyncReport 记录一次文件同步的结果
+type SyncReport struct {
+	Decisions   []FileDecision
+	Copied      []string      // 已复制到仓库的文件（仓库内路径）
+	Removed     []string      // 源文件已不存在而被删除的文件（仓库内路径）
+	Renamed     []RenamedFile // 检测到的重命名
+	Unchanged   int           // 内容未变化而跳过的文件数
+	Executables []string      // 本次复制的可执行文件（仓库内相对路径）
+	Dangling    []string      // 指向不存在目标的符号链接（相对源目录）
+	Collisions  []Collision   // 平铺目录时目标路径相同的文件
+}
+
+// RenamedFile 记录一次重命名
+type RenamedFile struct {
+	From string
+	To   string
+}
+
+// sourceFile 描述一个待同步的源文件
+type sourceFile struct {
+	path    string // 绝对路径
+	relPath string // 相对工作目录的路径（以 / 分隔）
+	srcRel  string // 相对源目录的路径（以 / 分隔）
+	source  int    // 所属源路径在 sources 中的序号
+	prefix  string // 所属源路径的目标前缀
+	info    os.FileInfo
+	link    string // 保留符号链接时的链接目标
+}
+
+// hash 返回源文件内容的哈希，符号链接使用链接目标计算
+func (f sourceFile) hash() (string, error) {
+	if f.link != "" {
+		return linkHash(f.link), nil
+	}
+	return hashFile(f.path)
+}
+
+// root 返回源目录的绝对路径，模式中不含通配符的前缀即为源目录
+// absolute 表示源路径本身是否为绝对路径
+func (s Source) root(workDir string) (root string, absolute bool) {
+	base, _ := doublestar.SplitPattern(normalizeSourcePath(s.Path))
+	root = filepath.FromSlash(base)
+	if filepath.IsAbs(root) {
+		return root, true
+	}
+	return filepath.Join(workDir, root), false
+}
+
+// ignoreFiles 返回遍历源目录时需要读取的忽略文件，后者优先级更高
+func (j *Job) ignoreFiles() []string {
+	if j.UseGitignore {
+		return []string{".gitignore", SyncIgnoreFile}
+	}
+	return []string{SyncIgnoreFile}
+}
+
 // syncFiles 同步文件
-func (gs *GitSync) syncFiles(job *Job) error {
+func (gs *GitSync) syncFiles(job *Job) (*SyncReport, error) {
 	if err := gs.validateJob(job); err != nil {
-		return err
+		return nil, err
 	}
 
-	// Normalize source and repo paths
-	sourcePath := filepath.ToSlash(job.SourcePath)
 	repoPath := job.GetRepoPath()
 
-	// Get absolute path of working directory
-	workDir, err := os.Getwd()
+	report := &SyncReport{}
+	var files []sourceFile
+	for i, src := range job.GetSources() {
+		collected, err := gs.collectFiles(job, i, src, report)
+		if err != nil {
+			return nil, err
+		}
+		if len(collected) == 0 {
+			gs.logger.Printf("WARNING: No matching files found for source: %s\n", src.Path)
+		}
+		files = append(files, collected...)
+	}
+
+	index, err := loadIndex(job)
 	if err != nil {
-		return fmt.Errorf("failed to get working directory: %v", err)
+		return nil, err
 	}
 
-	// Normalize matching pattern
-	pattern := normalizeSourcePath(sourcePath)
-	gs.logger.Printf("DEBUG: Using pattern: %s\n", pattern)
+	// 计算本次任务拥有的全部文件
+	desired, err := gs.planDestinations(job, files, report)
+	if err != nil {
+		return report, err
+	}
 
-	// Use filepath.Walk to traverse directory
-	var matches []string
-	err = filepath.Walk(filepath.Join(workDir, filepath.Dir(pattern)), func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			gs.logger.Printf("WARNING: Failed to access path %s: %v\n", path, err)
-			return nil
+	// 镜像模式下先处理重命名和删除
+	if job.Mirror {
+		gs.mirrorRemovals(job, index, desired, report)
+	} else {
+		for _, dest := range sortedKeys(index.Files) {
+			if _, ok := desired[dest]; !ok {
+				gs.logger.Printf("DEBUG: Source of %s no longer exists, keeping it because mirror is disabled\n", dest)
+			}
 		}
+	}
 
-		// Convert to relative path
-		relPath, err := filepath.Rel(workDir, path)
+	// Process matching files
+	for _, dest := range sortedKeys(desired) {
+		file := desired[dest]
+		destPath := filepath.Join(repoPath, filepath.FromSlash(dest))
+
+		// 源文件和仓库中的文件都与索引一致时跳过，否则比较内容哈希
+		entry, indexed := index.Files[dest]
+		if indexed && entry.unchanged(file.info, destPath) {
+			report.Unchanged++
+			continue
+		}
+		hash, err := file.hash()
 		if err != nil {
-			gs.logger.Printf("WARNING: Cannot get relative path for %s: %v\n", path, err)
-			return nil
+			gs.logger.Printf("WARNING: Failed to hash file %s: %v\n", file.path, err)
+			continue
 		}
+		if fileHashEquals(destPath, hash) {
+			// 内容相同时仍需同步权限和修改时间，例如脚本新增了可执行位
+			modeChanged, err := updateFileMetadata(file, destPath, job.PreserveTimes)
+			if err != nil {
+				gs.logger.Printf("WARNING: Failed to update metadata of %s: %v\n", destPath, err)
+				continue
+			}
+			index.Files[dest] = newIndexEntry(file, hash, destPath)
+			if !modeChanged {
+				report.Unchanged++
+				continue
+			}
+			gs.logger.Printf("Updated mode of file: %s to %v\n", destPath, file.info.Mode().Perm())
+		} else {
+			// Create destination directory
+			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+				gs.logger.Printf("WARNING: Failed to create directory %s: %v\n", filepath.Dir(destPath), err)
+				continue
+			}
 
-		// Convert to forward slash path for matching
-		relPath = filepath.ToSlash(relPath)
-
-		// Check if matches pattern
-		matched, err := doublestar.Match(pattern, relPath)
-		if err != nil {
-			gs.logger.Printf("WARNING: Pattern matching failed for %s: %v\n", relPath, err)
-			return nil
+			if file.link != "" {
+				err = copySymlink(file.link, destPath)
+			} else {
+				err = gs.copyFile(file.path, destPath, job.PreserveTimes)
+			}
+			if err != nil {
+				gs.logger.Printf("WARNING: Failed to copy file %s: %v\n", file.path, err)
+				continue
+			}
+			index.Files[dest] = newIndexEntry(file, hash, destPath)
+			gs.logger.Printf("Successfully synced file: %s to %s\n", file.relPath, destPath)
 		}
 
-		if matched && !info.IsDir() {
-			gs.logger.Printf("DEBUG: Found matching file: %s\n", relPath)
-			matches = append(matches, path)
+		report.Copied = append(report.Copied, dest)
+		if file.link == "" && file.info.Mode()&0111 != 0 {
+			report.Executables = append(report.Executables, dest)
 		}
-		return nil
-	})
+	}
 
-	if err != nil {
-		return fmt.Errorf("failed to traverse directory: %v", err)
+	if err := index.save(job); err != nil {
+		return report, fmt.Errorf("failed to save index: %v", err)
 	}
 
-	if len(matches) == 0 {
-		gs.logger.Printf("WARNING: No matching files found for pattern: %s\n", pattern)
-		return nil
+	return report, nil
+}
+
+// mirrorRemovals 删除任务拥有但源中已不存在的文件，内容相同的新文件视为重命名
+// 不在索引中的文件（例如目标仓库中手动提交的文件）不会被改动
+func (gs *GitSync) mirrorRemovals(job *Job, index *SyncIndex, desired map[string]sourceFile, report *SyncReport) {
+	repoPath := job.GetRepoPath()
+
+	// 新增文件按内容哈希分组，用于识别重命名
+	added := make(map[string][]string)
+	for dest, file := range desired {
+		if _, owned := index.Files[dest]; owned {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(repoPath, filepath.FromSlash(dest))); err == nil {
+			continue
+		}
+		if hash, err := file.hash(); err == nil {
+			added[hash] = append(added[hash], dest)
+		}
 	}
 
-	// Process matching files
-	for _, path := range matches {
-		relPath, _ := filepath.Rel(workDir, path)
-		relPath = filepath.ToSlash(relPath)
-
-		// Determine destination path
-		var destPath string
-		switch {
-		case job.KeepStructure:
-			destPath = filepath.Join(repoPath, relPath)
-		case job.RemotePath != "":
-			destPath = filepath.Join(repoPath, job.RemotePath, filepath.Base(path))
-		default:
-			destPath = filepath.Join(repoPath, filepath.Base(path))
-		}
-
-		// Create destination directory
-		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-			gs.logger.Printf("WARNING: Failed to create directory %s: %v\n", filepath.Dir(destPath), err)
+	for _, dest := range sortedKeys(index.Files) {
+		if _, ok := desired[dest]; ok {
 			continue
 		}
+		oldPath := filepath.Join(repoPath, filepath.FromSlash(dest))
+		entry := index.Files[dest]
+		delete(index.Files, dest)
 
-		if err := gs.copyFile(path, destPath); err != nil {
-			gs.logger.Printf("WARNING: Failed to copy file %s: %v\n", path, err)
+		if _, err := os.Lstat(oldPath); os.IsNotExist(err) {
 			continue
 		}
 
-		gs.logger.Printf("Successfully synced file: %s to %s\n", relPath, destPath)
-	}
+		hash := entry.Hash
+		if hash == "" {
+			hash, _ = destHash(oldPath)
+		}
+		if hash != "" && len(added[hash]) > 0 {
+			newDest := added[hash][0]
+			added[hash] = added[hash][1:]
+			newPath := filepath.Join(repoPath, filepath.FromSlash(newDest))
+			if err := os.MkdirAll(filepath.Dir(newPath), 0755); err == nil && os.Rename(oldPath, newPath) == nil {
+				removeEmptyDirs(filepath.Dir(oldPath), repoPath)
+				report.Renamed = append(report.Renamed, RenamedFile{From: dest, To: newDest})
+				gs.logger.Printf("Renamed file in mirror: %s -> %s\n", dest, newDest)
+				continue
+			}
+		}
 
-	return nil
+		if err := os.Remove(oldPath); err != nil {
+			gs.logger.Printf("WARNING: Failed to remove file %s: %v\n", oldPath, err)
+			index.Files[dest] = entry
+			continue
+		}
+		removeEmptyDirs(filepath.Dir(oldPath), repoPath)
+		report.Removed = append(report.Removed, dest)
+		gs.logger.Printf("Removed file from mirror: %s\n", dest)
+	}
 }
 
-// shouldSync 检查文件是否应该被同步
-func (gs *GitSync) shouldSync(path string, includes, excludes []string) bool {
-	// 首先检查排除规则
-	for _, exclude := range excludes {
-		matched, err := doublestar.Match(exclude, path)
-		if err == nil && matched {
-			gs.logger.Printf("DEBUG: file %s excluded by rule %s\n", path, exclude)
-			return false
+// removeEmptyDirs 自下而上删除空目录，直到 stop 目录为止
+func removeEmptyDirs(dir, stop string) {
+	stop = filepath.Clean(stop)
+	for dir = filepath.Clean(dir); dir != stop && strings.HasPrefix(dir, stop); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
 		}
 	}
+}
 
-	// 如果没有包含规则，则包含所有文件
-	if len(includes) == 0 {
-		return true
+// sortedKeys 返回按字典序排列的 map 键
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
+	return keys
+}
 
-	// 检查包含规则
-	for _, include := range includes {
-		matched, err := doublestar.Match(include, path)
-		if err == nil && matched {
-			return true
-		}
+// shouldSync 检查文件是否应该被同步
+func (gs *GitSync) shouldSync(path string, includes, excludes []string) bool {
+	decision := NewFilter(includes, excludes).Match(filepath.ToSlash(path), false)
+	if !decision.Included {
+		gs.logger.Printf("DEBUG: file %s excluded by %s\n", path, decision.Reason())
 	}
-
-	return false
+	return decision.Included
 }
 
-// copyFile 复制文件
-func (gs *GitSync) copyFile(src, dst string) error {
+// copyFile 复制文件，保留文件权限，可选保留修改时间
+// 先写入同目录下的临时文件再原子重命名，避免中断时留下不完整的文件
+func (gs *GitSync) copyFile(src, dst string, preserveTimes bool) (err error) {
 	// 确保目标目录存在
 	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
 		return err
@@ -515,152 +1148,214 @@ func (gs *GitSync) copyFile(src, dst string) error {
 	}
 	defer srcFile.Close()
 
-	dstFile, err := os.Create(dst)
+	info, err := srcFile.Stat()
 	if err != nil {
 		return err
 	}
-	defer dstFile.Close()
 
-	_, err = io.Copy(dstFile, srcFile)
-	return err
+	tmpFile, err := os.CreateTemp(filepath.Dir(dst), TempFilePrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmpFile.Close()
+			os.Remove(tmpFile.Name())
+		}
+	}()
+
+	if _, err = io.Copy(tmpFile, srcFile); err != nil {
+		return err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmpFile.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	if preserveTimes {
+		if err = os.Chtimes(tmpFile.Name(), info.ModTime(), info.ModTime()); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(tmpFile.Name(), dst)
 }
 
-// commitChanges 提交更改并推送到远程
-func (gs *GitSync) commitChanges(user *User, job *Job) error {
+// updateFileMetadata 使内容相同的目标文件的权限（以及 preserveTimes 时的修改时间）与源文件一致
+// 返回权限是否发生变化，修改时间不被 git 记录，不算作变化
+func updateFileMetadata(file sourceFile, dst string, preserveTimes bool) (modeChanged bool, err error) {
+	if file.link != "" {
+		return false, nil
+	}
+	info, err := os.Lstat(dst)
+	if err != nil {
+		return false, err
+	}
+	if info.Mode().Perm() != file.info.Mode().Perm() {
+		if err := os.Chmod(dst, file.info.Mode().Perm()); err != nil {
+			return false, err
+		}
+		modeChanged = true
+	}
+	if preserveTimes && !info.ModTime().Equal(file.info.ModTime()) {
+		if err := os.Chtimes(dst, file.info.ModTime(), file.info.ModTime()); err != nil {
+			return modeChanged, err
+		}
+	}
+	return modeChanged, nil
+}
+
+// copySymlink 在仓库中创建指向 target 的符号链接，替换已存在的文件
+func copySymlink(target, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(filepath.Dir(dst), TempFilePrefix+filepath.Base(dst))
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// commitChanges 提交更改并推送到远程，返回本次提交的变化，没有变化时返回 nil
+// 提交成功但推送失败时同时返回变化和错误
+func (gs *GitSync) commitChanges(user *User, job *Job, report *SyncReport) (*ChangeSet, error) {
 	repoPath := job.GetRepoPath()
 	gs.logger.Printf("DEBUG: Starting commit process for job: %s in directory: %s\n", job.Name, repoPath)
 
 	// 确保我们在正确的目录中操作
 	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
-		return fmt.Errorf("repository directory does not exist: %s", repoPath)
+		return nil, fmt.Errorf("repository directory does not exist: %s", repoPath)
 	}
 
 	// 检查 git 状态
-	cmd := exec.Command("git", "status", "--porcelain")
-	cmd.Dir = repoPath
+	cmd := gitCommand(user, repoPath, "status", "--porcelain")
 	output, err := cmd.Output()
 	if err != nil {
 		gs.logger.Printf("ERROR: Git status failed in %s: %v\n", repoPath, err)
-		return fmt.Errorf("failed to check git status: %v", err)
+		return nil, newGitError("git status", nil, err)
 	}
 
 	gs.logger.Printf("DEBUG: Git status output in %s:\n%s", repoPath, string(output))
 
 	if len(output) == 0 {
 		gs.logger.Println("DEBUG: No changes to commit")
-		return nil
+		// 上次推送或变基失败时提交仍留在本地分支上，需要重新推送
+		if job.RemoteURL != "" && job.publish() == PublishPush {
+			ahead, err := unpushedCommits(user, repoPath, job.Branch)
+			if err != nil {
+				return nil, err
+			}
+			if ahead > 0 {
+				gs.logger.Printf("Pushing %d unpushed commit(s) of job %s\n", ahead, job.Name)
+				if err := gs.pushJob(user, repoPath, job, report); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return nil, nil
 	}
 
 	// 添加所有更改
 	gs.logger.Println("DEBUG: Adding changes to git")
-	addCmd := exec.Command("git", "add", ".")
-	addCmd.Dir = repoPath
+	addCmd := gitCommand(user, repoPath, "add", "-A")
 	if output, err := addCmd.CombinedOutput(); err != nil {
 		gs.logger.Printf("ERROR: Git add failed: %s\n", string(output))
-		return fmt.Errorf("git add failed: %v", err)
-	}
-
-	// 设置 Git 配置
-	gs.logger.Printf("DEBUG: Setting git config for user: %s\n", user.Username)
-	configCmds := []struct {
-		args []string
-		msg  string
-	}{
-		{[]string{"config", "user.name", user.Username}, "set user.name"},
-		{[]string{"config", "user.email", user.Email}, "set user.email"},
+		return nil, newGitError("git add", output, err)
 	}
 
-	for _, cfg := range configCmds {
-		cmd := exec.Command("git", cfg.args...)
-		cmd.Dir = repoPath
-		if output, err := cmd.CombinedOutput(); err != nil {
-			gs.logger.Printf("ERROR: Failed to %s: %s\n", cfg.msg, string(output))
-			return fmt.Errorf("failed to %s: %v", cfg.msg, err)
+	// 在 core.fileMode 关闭的系统上（如 Windows）显式记录可执行位
+	if len(report.Executables) > 0 {
+		chmodCmd := gitCommand(user, repoPath, append([]string{"update-index", "--chmod=+x", "--"}, report.Executables...)...)
+		if output, err := chmodCmd.CombinedOutput(); err != nil {
+			gs.logger.Printf("WARNING: Failed to set executable bit: %s\n", string(output))
 		}
 	}
 
 	// 提交更改
-	commitMsg := fmt.Sprintf("Sync update by %s: %s", user.Username, time.Now().Format("2006-01-02 15:04:05"))
+	changes, err := stagedChanges(user, repoPath)
+	if err != nil {
+		return nil, err
+	}
+	commitMsg, err := commitMessage(user, job, changes)
+	if err != nil {
+		return nil, err
+	}
 	gs.logger.Printf("DEBUG: Committing with message: %s\n", commitMsg)
-	commitCmd := exec.Command("git", "commit", "-m", commitMsg)
-	commitCmd.Dir = repoPath
+	commitArgs := []string{"commit", "-m", commitMsg}
+	if job.Signoff {
+		commitArgs = append(commitArgs, "--signoff")
+	}
+	commitCmd := gitCommand(user, repoPath, commitArgs...)
 	if output, err := commitCmd.CombinedOutput(); err != nil {
 		gs.logger.Printf("ERROR: Git commit failed: %s\n", string(output))
-		return fmt.Errorf("git commit failed: %v", err)
-	}
-
-	if job.RemoteURL != "" {
-		// 先获取远程更新
-		gs.logger.Printf("DEBUG: Fetching from remote\n")
-		fetchCmd := exec.Command("git", "fetch", "origin", job.Branch)
-		fetchCmd.Dir = repoPath
-		if output, err := fetchCmd.CombinedOutput(); err != nil {
-			gs.logger.Printf("WARNING: Git fetch failed: %s\n", string(output))
+		if user.SigningKey != "" && isSigningFailure(string(output)) {
+			return nil, fmt.Errorf("commit signing with %s key %s failed: %s",
+				user.signingFormat(), user.SigningKey, strings.TrimSpace(string(output)))
 		}
+		return nil, newGitError("git commit", output, err)
+	}
 
-		// 根据合策略处理
-		switch strings.ToLower(job.MergeStrategy) {
-		case "rebase":
-			// 使用 rebase 策略
-			if err := gs.rebaseAndPush(repoPath, job); err != nil {
-				return err
-			}
-		case "force":
-			// 使用强制推送策略
-			if err := gs.forcePush(repoPath, job); err != nil {
-				return err
-			}
-		default:
-			// 默认使用普通推送
-			if err := gs.normalPush(repoPath, job); err != nil {
-				return err
-			}
+	// 拉取请求模式由 publishPullRequest 推送
+	if job.RemoteURL != "" && job.publish() == PublishPush {
+		if err := gs.pushJob(user, repoPath, job, report); err != nil {
+			return changes, err
 		}
-		gs.logger.Printf("DEBUG: Successfully pushed to remote repository on branch %s\n", job.Branch)
 	}
 
-	return nil
+	return changes, nil
 }
 
-// 添加以下辅助方法
-
-// rebaseAndPush 执行 rebase 并推送
-func (gs *GitSync) rebaseAndPush(repoPath string, job *Job) error {
-	gs.logger.Printf("DEBUG: Rebasing with remote branch: %s\n", job.Branch)
-	rebaseCmd := exec.Command("git", "rebase", fmt.Sprintf("origin/%s", job.Branch))
-	rebaseCmd.Dir = repoPath
-	if output, err := rebaseCmd.CombinedOutput(); err != nil {
-		gs.logger.Printf("ERROR: Rebase failed: %s\n", string(output))
-		// 中止 rebase
-		abortCmd := exec.Command("git", "rebase", "--abort")
-		abortCmd.Dir = repoPath
-		abortCmd.Run()
-		return fmt.Errorf("git rebase failed: %v", err)
+// pushJob 按任务的合并策略推送本地分支
+func (gs *GitSync) pushJob(user *User, repoPath string, job *Job, report *SyncReport) error {
+	owned, err := ownedPaths(job, report)
+	if err != nil {
+		return err
+	}
+	if err := gs.pushChanges(user, repoPath, job, owned); err != nil {
+		return err
 	}
+	gs.logger.Printf("DEBUG: Successfully pushed to remote repository on branch %s\n", job.Branch)
+	return nil
+}
 
-	return gs.normalPush(repoPath, job)
+// unpushedCommits 返回本地分支上尚未推送到 origin 的提交数，远程分支不存在时为本地全部提交数
+func unpushedCommits(user *User, repoPath, branch string) (int, error) {
+	if err := gitCommand(user, repoPath, "rev-parse", "--verify", "--quiet", "HEAD").Run(); err != nil {
+		return 0, nil
+	}
+	rangeArg := "HEAD"
+	if hasRemoteBranch(user, repoPath, branch) {
+		rangeArg = "origin/" + branch + "..HEAD"
+	}
+	output, err := gitCommand(user, repoPath, "rev-list", "--count", rangeArg).CombinedOutput()
+	if err != nil {
+		return 0, newGitError("git rev-list", output, err)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse commit count %q: %v", strings.TrimSpace(string(output)), err)
+	}
+	return count, nil
 }
 
 // forcePush 执行强制推送
-func (gs *GitSync) forcePush(repoPath string, job *Job) error {
+func (gs *GitSync) forcePush(user *User, repoPath string, job *Job) error {
 	gs.logger.Printf("DEBUG: Force pushing to remote branch: %s\n", job.Branch)
-	pushCmd := exec.Command("git", "push", "-f", "origin", job.Branch)
-	pushCmd.Dir = repoPath
+	pushCmd := gitCommand(user, repoPath, "push", "-f", "origin", job.Branch)
 	if output, err := pushCmd.CombinedOutput(); err != nil {
 		gs.logger.Printf("ERROR: Force push failed: %s\n", string(output))
-		return fmt.Errorf("git force push failed: %v", err)
-	}
-	return nil
-}
-
-// normalPush 执行普通推送
-func (gs *GitSync) normalPush(repoPath string, job *Job) error {
-	gs.logger.Printf("DEBUG: Pushing to remote branch: %s\n", job.Branch)
-	pushCmd := exec.Command("git", "push", "origin", job.Branch)
-	pushCmd.Dir = repoPath
-	if output, err := pushCmd.CombinedOutput(); err != nil {
-		gs.logger.Printf("ERROR: Push failed: %s\n", string(output))
-		return fmt.Errorf("git push failed: %v", err)
+		return newGitError("git push --force", output, err)
 	}
 	return nil
 }
@@ -676,23 +1371,32 @@ func sanitizePath(name string) string {
 	return safe
 }
 
-func startDaemon() error {
+// startDaemon 在后台启动同步服务并写入 pid 文件
+// child 为 true 表示当前进程就是后台进程，退出前需要调用 release 释放 pid 文件
+func startDaemon() (child bool, release func(), err error) {
 	if runtime.GOOS == "windows" {
+		if pid, err := readPidFile(PidFile); err == nil && processRunning(pid) {
+			return false, nil, fmt.Errorf("git-syncer is already running with PID %d", pid)
+		}
+
 		// Windows: 使用简单的后台运行方案
 		cmd := exec.Command(os.Args[0], os.Args[1:]...)
 		cmd.Args = append(cmd.Args, "-nodaemon") // 防止递归
 		if err := cmd.Start(); err != nil {
-			return fmt.Errorf("failed to start daemon: %v", err)
+			return false, nil, fmt.Errorf("failed to start daemon: %v", err)
+		}
+		if err := os.WriteFile(PidFile, []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), 0644); err != nil {
+			return false, nil, fmt.Errorf("failed to write pid file: %v", err)
 		}
 		fmt.Println("Git-Syncer is running in background with PID:", cmd.Process.Pid)
-		return nil
+		return false, nil, nil
 	}
 
 	// POSIX systems: 使用 go-daemon
 	cntxt := &daemon.Context{
-		PidFileName: "git-syncer.pid",
+		PidFileName: PidFile,
 		PidFilePerm: 0644,
-		LogFileName: "git-syncer-daemon.log",
+		LogFileName: DaemonLogFile,
 		LogFilePerm: 0640,
 		WorkDir:     "./",
 		Umask:       027,
@@ -700,91 +1404,25 @@ func startDaemon() error {
 
 	d, err := cntxt.Reborn()
 	if err != nil {
-		return fmt.Errorf("unable to run: %v", err)
+		return false, nil, fmt.Errorf("unable to run: %v", err)
 	}
 	if d != nil {
 		fmt.Println("Git-Syncer daemon started. Check git-syncer-daemon.log for details")
-		return nil
+		return false, nil, nil
 	}
 
-	defer cntxt.Release()
 	fmt.Print(Banner)
 	fmt.Printf("Git-Syncer %s daemon started\n", Version)
-	return nil
+	return true, func() { cntxt.Release() }, nil
 }
 
-var (
-	// 版本相关标志
-	showVersion bool
-	// daemon 模式标志
-	daemonFlag bool
-	// 配置文件路径
-	configFile string
-	// 帮助标志
-	help bool
-	// 防止递归的标志
-	noDaemon bool
-)
-
 func main() {
-
-	flag.BoolVar(&daemonFlag, "d", false, "Run as daemon")
-	flag.BoolVar(&noDaemon, "nodaemon", false, "Internal flag to prevent recursive daemon")
-	flag.BoolVar(&showVersion, "v", false, "Show version information")
-	flag.BoolVar(&showVersion, "version", false, "Show version information (same as -v)")
-	flag.StringVar(&configFile, "c", "config.yml", "Path to config file")
-	flag.BoolVar(&help, "h", false, "Show help information")
-
-	flag.Parse()
-
-	// 检查帮助标志
-	if len(os.Args) == 1 || help {
-		fmt.Print(Banner)
-		fmt.Printf("Git-Syncer %s\n\n", Version)
-		fmt.Println("Usage:")
-		fmt.Printf("  %s [options]\n\n", os.Args[0])
-		fmt.Println("Options:")
-		flag.PrintDefaults()
-		return
-	}
-
-	// 检查版本标志
-	if showVersion {
-		// 显示 banner
-		fmt.Print(Banner)
-		// 显示版本信息
-		fmt.Printf("Git-Syncer %s (Build: %s, Commit: %s)\n", Version, BuildTime, GitCommit)
-		return
+	// 被 git 作为 GIT_ASKPASS 调用时只输出认证信息
+	if isAskpass() {
+		os.Exit(runAskpass(os.Args[1:]))
 	}
 
-	if daemonFlag && !noDaemon {
-		if err := startDaemon(); err != nil {
-			log.Fatal("Failed to start daemon: ", err)
-		}
-		if runtime.GOOS == "windows" {
-			return // Windows 后台进程已启动，退出当前进程
-		}
-	}
-
-	if configFile == "" {
-		fmt.Println("No config file provided, using default: config.yml")
-		// 检查 config.yml 是否存在
-		if _, err := os.Stat("config.yml"); os.IsNotExist(err) {
-			fmt.Println("Default config file not found, please provide a valid config file with -c option")
-			os.Exit(1)
-		}
-
-	}
-
-	// 使用配置文件标志
-	sync, err := NewGitSync(configFile)
-	if err != nil {
-		log.Fatalf("Failed to create GitSync: %v", err)
-	}
-
-	if err := sync.Run(); err != nil {
-		log.Fatalf("Failed to run GitSync: %v", err)
-	}
+	os.Exit(runCLI(os.Args[1:]))
 }
 
 // 更新辅助函数来处理路径
@@ -4,7 +4,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 )
 
 // 测试配置文件
@@ -15,8 +17,7 @@ users:
     jobs:
       - name: "test-job"
         schedule: "* * * * *"
-        source_path: "./testdata/source"
-        repo_path: "./testdata/repo"
+        source_path: "."
         includes: ["*.txt"]
         excludes: ["*.tmp"]
 `
@@ -101,7 +102,7 @@ func TestSyncFiles(t *testing.T) {
 	}
 
 	// 测试文件同步
-	err := gs.syncFiles(job)
+	_, err := gs.syncFiles(job)
 	if err != nil {
 		t.Fatalf("syncFiles failed: %v", err)
 	}
@@ -165,3 +166,353 @@ func checkSyncedFiles(t *testing.T, job *Job) {
 		t.Errorf("Expected 0 excluded files, got %d", len(excluded))
 	}
 }
+
+func TestSyncFilesMirror(t *testing.T) {
+	testDir := setupTestDirectory(t)
+	defer os.RemoveAll(testDir)
+
+	gs := &GitSync{logger: createTestLogger()}
+	job := &Job{
+		Name:       "mirror-test",
+		SourcePath: filepath.Join(testDir, "source"),
+		Excludes:   []string{"*.tmp"},
+		Mirror:     true,
+	}
+	repoPath := job.GetRepoPath()
+	defer os.RemoveAll(repoPath)
+	defer os.Remove(indexPath(job))
+
+	if _, err := gs.syncFiles(job); err != nil {
+		t.Fatalf("syncFiles failed: %v", err)
+	}
+
+	// 仓库中不属于任务的文件
+	createTestFile(t, filepath.Join(repoPath, "README.md"), "maintained by hand")
+
+	// 删除一个源文件并重命名另一个
+	sourcePath := filepath.Join(testDir, "source")
+	os.Remove(filepath.Join(sourcePath, "test1.txt"))
+	os.Rename(filepath.Join(sourcePath, "test2.txt"), filepath.Join(sourcePath, "renamed.txt"))
+
+	report, err := gs.syncFiles(job)
+	if err != nil {
+		t.Fatalf("syncFiles failed: %v", err)
+	}
+
+	if len(report.Removed) != 1 || report.Removed[0] != "test1.txt" {
+		t.Errorf("Expected test1.txt to be removed, got %v", report.Removed)
+	}
+	if len(report.Renamed) != 1 || report.Renamed[0] != (RenamedFile{From: "test2.txt", To: "renamed.txt"}) {
+		t.Errorf("Expected test2.txt to be renamed, got %v", report.Renamed)
+	}
+
+	for name, want := range map[string]bool{
+		"test1.txt":   false,
+		"test2.txt":   false,
+		"renamed.txt": true,
+		"README.md":   true,
+	} {
+		_, err := os.Stat(filepath.Join(repoPath, name))
+		if exists := err == nil; exists != want {
+			t.Errorf("Expected %s exists=%v, got %v", name, want, exists)
+		}
+	}
+}
+
+func TestCollectFilesIgnoreFiles(t *testing.T) {
+	testDir := setupTestDirectory(t)
+	defer os.RemoveAll(testDir)
+
+	sourcePath := filepath.Join(testDir, "source")
+	os.MkdirAll(filepath.Join(sourcePath, "scratch"), 0755)
+	os.MkdirAll(filepath.Join(sourcePath, "logs"), 0755)
+	createTestFile(t, filepath.Join(sourcePath, SyncIgnoreFile), "scratch/\n")
+	createTestFile(t, filepath.Join(sourcePath, "scratch", "notes.txt"), "private")
+	createTestFile(t, filepath.Join(sourcePath, "logs", SyncIgnoreFile), "*.log\n!keep.log\n")
+	createTestFile(t, filepath.Join(sourcePath, "logs", "debug.log"), "noise")
+	createTestFile(t, filepath.Join(sourcePath, "logs", "keep.log"), "important")
+	createTestFile(t, filepath.Join(sourcePath, "root.log"), "outside logs/")
+
+	gs := &GitSync{logger: createTestLogger()}
+	job := &Job{
+		SourcePath: sourcePath,
+		Excludes:   []string{"*.tmp"},
+	}
+
+	files, err := gs.collectFiles(job, 0, job.GetSources()[0], &SyncReport{})
+	if err != nil {
+		t.Fatalf("collectFiles failed: %v", err)
+	}
+
+	got := make(map[string]bool)
+	for _, file := range files {
+		rel, _ := filepath.Rel(sourcePath, file.path)
+		got[filepath.ToSlash(rel)] = true
+	}
+
+	want := []string{"test1.txt", "test2.txt", "logs/keep.log", "root.log"}
+	if len(got) != len(want) {
+		t.Errorf("Expected %d files, got %v", len(want), got)
+	}
+	for _, name := range want {
+		if !got[name] {
+			t.Errorf("Expected %s to be collected, got %v", name, got)
+		}
+	}
+}
+
+func TestSyncFilesIncremental(t *testing.T) {
+	testDir := setupTestDirectory(t)
+	defer os.RemoveAll(testDir)
+
+	gs := &GitSync{logger: createTestLogger()}
+	job := &Job{
+		Name:       "incremental-test",
+		SourcePath: filepath.Join(testDir, "source"),
+		Excludes:   []string{"*.tmp"},
+	}
+	defer os.RemoveAll(job.GetRepoPath())
+	defer os.Remove(indexPath(job))
+
+	report, err := gs.syncFiles(job)
+	if err != nil {
+		t.Fatalf("syncFiles failed: %v", err)
+	}
+	if len(report.Copied) != 2 {
+		t.Fatalf("Expected 2 copied files on first run, got %d", len(report.Copied))
+	}
+
+	// 未修改的文件不再复制
+	createTestFile(t, filepath.Join(testDir, "source", "test1.txt"), "changed content")
+	report, err = gs.syncFiles(job)
+	if err != nil {
+		t.Fatalf("syncFiles failed: %v", err)
+	}
+	if len(report.Copied) != 1 || report.Unchanged != 1 {
+		t.Errorf("Expected 1 copied and 1 unchanged file, got %d and %d", len(report.Copied), report.Unchanged)
+	}
+
+	// 重建索引后内容相同的文件仍被跳过
+	index, err := loadIndex(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.rehash()
+	if err := index.save(job); err != nil {
+		t.Fatal(err)
+	}
+	report, err = gs.syncFiles(job)
+	if err != nil {
+		t.Fatalf("syncFiles failed: %v", err)
+	}
+	if len(report.Copied) != 0 || report.Unchanged != 2 {
+		t.Errorf("Expected 0 copied and 2 unchanged files after rehash, got %d and %d", len(report.Copied), report.Unchanged)
+	}
+
+	// 仓库中的文件被改写（例如 rebase 拉取了远程的修改）时重新复制
+	repoFile := filepath.Join(job.GetRepoPath(), "test1.txt")
+	createTestFile(t, repoFile, "remote  content")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(repoFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	report, err = gs.syncFiles(job)
+	if err != nil {
+		t.Fatalf("syncFiles failed: %v", err)
+	}
+	if len(report.Copied) != 1 || report.Unchanged != 1 {
+		t.Errorf("Expected the modified repo file to be copied again, got %d copied and %d unchanged", len(report.Copied), report.Unchanged)
+	}
+	if data, err := os.ReadFile(repoFile); err != nil || string(data) != "changed content" {
+		t.Errorf("Expected the repo file to be restored, got %q (%v)", data, err)
+	}
+}
+
+func TestSyncFilesModeChange(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file mode is not supported on Windows")
+	}
+	testDir := setupTestDirectory(t)
+	defer os.RemoveAll(testDir)
+
+	gs := &GitSync{logger: createTestLogger()}
+	job := &Job{
+		Name:          "mode-change-test",
+		SourcePath:    filepath.Join(testDir, "source"),
+		Includes:      []string{"test1.txt"},
+		PreserveTimes: true,
+	}
+	defer os.RemoveAll(job.GetRepoPath())
+	defer os.Remove(indexPath(job))
+
+	if _, err := gs.syncFiles(job); err != nil {
+		t.Fatalf("syncFiles failed: %v", err)
+	}
+
+	// 只修改权限和修改时间，内容不变
+	src := filepath.Join(testDir, "source", "test1.txt")
+	if err := os.Chmod(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := gs.syncFiles(job)
+	if err != nil {
+		t.Fatalf("syncFiles failed: %v", err)
+	}
+	if len(report.Copied) != 1 || len(report.Executables) != 1 || report.Unchanged != 0 {
+		t.Errorf("Expected the mode change to be synced, got copied=%v executables=%v unchanged=%d",
+			report.Copied, report.Executables, report.Unchanged)
+	}
+
+	info, err := os.Stat(filepath.Join(job.GetRepoPath(), "test1.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("Expected mode 0755, got %v", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("Expected mtime %v, got %v", mtime, info.ModTime())
+	}
+
+	// 再次同步时不再有变化
+	report, err = gs.syncFiles(job)
+	if err != nil {
+		t.Fatalf("syncFiles failed: %v", err)
+	}
+	if len(report.Copied) != 0 || report.Unchanged != 1 {
+		t.Errorf("Expected 0 copied and 1 unchanged file, got %v and %d", report.Copied, report.Unchanged)
+	}
+}
+
+func TestCopyFilePreservesMode(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "run.sh")
+	dst := filepath.Join(dir, "out", "run.sh")
+	createTestFile(t, src, "#!/bin/sh\necho ok\n")
+	if err := os.Chmod(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	gs := &GitSync{logger: createTestLogger()}
+	if err := gs.copyFile(src, dst, true); err != nil {
+		t.Fatalf("copyFile failed: %v", err)
+	}
+
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0755 {
+		t.Errorf("Expected mode 0755, got %v", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("Expected mtime %v, got %v", mtime, info.ModTime())
+	}
+
+	// 不应留下临时文件
+	leftovers, _ := filepath.Glob(filepath.Join(dir, "out", TempFilePrefix+"*"))
+	if len(leftovers) != 0 {
+		t.Errorf("Expected no temporary files, got %v", leftovers)
+	}
+}
+
+func TestSyncFilesMultipleSources(t *testing.T) {
+	testDir := setupTestDirectory(t)
+	defer os.RemoveAll(testDir)
+
+	otherPath := filepath.Join(testDir, "other")
+	os.MkdirAll(otherPath, 0755)
+	createTestFile(t, filepath.Join(otherPath, "test1.txt"), "other content")
+	createTestFile(t, filepath.Join(otherPath, "extra.md"), "extra")
+
+	gs := &GitSync{logger: createTestLogger()}
+	job := &Job{
+		Name:     "multi-source-test",
+		Excludes: []string{"*.tmp"},
+		Sources: []Source{
+			{Path: filepath.Join(testDir, "source"), Prefix: "main"},
+			{Path: otherPath, Prefix: "other", Excludes: []string{"*.md"}},
+		},
+	}
+	repoPath := job.GetRepoPath()
+	defer os.RemoveAll(repoPath)
+	defer os.Remove(indexPath(job))
+
+	report, err := gs.syncFiles(job)
+	if err != nil {
+		t.Fatalf("syncFiles failed: %v", err)
+	}
+	if len(report.Copied) != 3 {
+		t.Errorf("Expected 3 copied files, got %v", report.Copied)
+	}
+	for _, name := range []string{"main/test1.txt", "main/test2.txt", "other/test1.txt"} {
+		if _, err := os.Stat(filepath.Join(repoPath, name)); err != nil {
+			t.Errorf("Expected %s to be synced: %v", name, err)
+		}
+	}
+
+	// 两个源路径映射到同一目标路径
+	job.Sources[1].Prefix = "main"
+	report, err = gs.syncFiles(job)
+	if err == nil {
+		t.Fatal("Expected conflict error, got nil")
+	}
+	if len(report.Collisions) != 1 || report.Collisions[0].Dest != "main/test1.txt" {
+		t.Errorf("Expected conflict on main/test1.txt, got %v", report.Collisions)
+	}
+}
+
+func TestRunJobWaitsForJobLock(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	job := &Job{
+		Name:       "lock-test",
+		SourcePath: filepath.Join(t.TempDir(), "missing"),
+		RemoteURL:  "file://" + filepath.ToSlash(filepath.Join(t.TempDir(), "missing.git")),
+	}
+	defer os.RemoveAll(job.GetRepoPath())
+	defer os.Remove(indexPath(job))
+
+	// 模拟另一个进程（例如 sync 子命令）正在执行该任务
+	release, err := acquireLock(jobLockPath(job), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acquireLock(jobLockPath(job), 0); err == nil {
+		t.Fatal("Expected the job lock to be held")
+	}
+
+	gs := &GitSync{logger: createTestLogger(), config: &Config{}}
+	done := make(chan SyncResult, 1)
+	go func() {
+		done <- gs.runJob(&User{Username: "test", Email: "test@example.com"}, job)
+	}()
+	select {
+	case <-done:
+		t.Fatal("runJob ran while the job lock was held")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	release()
+	select {
+	case result := <-done:
+		if result.Stage != StageInit {
+			t.Errorf("Expected the job to fail at init, got %+v", result)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("runJob did not run after the job lock was released")
+	}
+	if _, err := os.Stat(jobLockPath(job)); !os.IsNotExist(err) {
+		t.Errorf("Expected the job lock to be released, got %v", err)
+	}
+}
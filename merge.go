@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 合并策略
+const (
+	MergeNormal = "normal" // 直接推送（默认）
+	MergeRebase = "rebase" // 变基到远程分支后推送
+	MergeMerge  = "merge"  // 合并远程分支后推送
+	MergeForce  = "force"  // 强制推送
+
+	// DefaultPushRetries 推送因远程有新提交被拒绝时默认的重试次数
+	DefaultPushRetries = 3
+)
+
+// 合并冲突的处理方式
+const (
+	ConflictFail   = "fail"   // 中止并报告失败（默认）
+	ConflictOurs   = "ours"   // 任务拥有的文件使用本次同步的版本
+	ConflictTheirs = "theirs" // 任务拥有的文件使用远程版本
+)
+
+// maxConflictRounds 变基时逐个提交解决冲突的最大轮数
+const maxConflictRounds = 100
+
+// mergeStrategy 返回任务的合并策略
+func (j *Job) mergeStrategy() string {
+	if j.MergeStrategy == "" {
+		return MergeNormal
+	}
+	return strings.ToLower(j.MergeStrategy)
+}
+
+// onConflict 返回任务的冲突处理方式
+func (j *Job) onConflict() string {
+	if j.OnConflict == "" {
+		return ConflictFail
+	}
+	return strings.ToLower(j.OnConflict)
+}
+
+// pushRetries 返回推送被拒绝时的重试次数
+func (j *Job) pushRetries() int {
+	if j.PushRetries <= 0 {
+		return DefaultPushRetries
+	}
+	return j.PushRetries
+}
+
+// pushChanges 获取远程更新，按合并策略整合后推送
+// rebase 和 merge 策略下推送因其他人抢先推送被拒绝时，重新获取并整合后重试
+func (gs *GitSync) pushChanges(user *User, repoPath string, job *Job, owned map[string]bool) error {
+	strategy := job.mergeStrategy()
+	attempts := 1
+	if strategy == MergeRebase || strategy == MergeMerge {
+		attempts += job.pushRetries()
+	}
+
+	for attempt := 1; ; attempt++ {
+		// 先获取远程更新
+		gs.logger.Printf("DEBUG: Fetching from remote\n")
+		if err := gs.fetchBranch(user, repoPath, job.Branch); err != nil {
+			gs.logger.Printf("WARNING: Git fetch failed: %v\n", err)
+		}
+
+		switch strategy {
+		case MergeForce:
+			return gs.forcePush(user, repoPath, job)
+		case MergeRebase:
+			if err := gs.rebaseOnRemote(user, repoPath, job, owned); err != nil {
+				return err
+			}
+		case MergeMerge:
+			if err := gs.mergeRemote(user, repoPath, job, owned); err != nil {
+				return err
+			}
+		}
+
+		gs.logger.Printf("DEBUG: Pushing to remote branch: %s\n", job.Branch)
+		pushCmd := gitCommand(user, repoPath, "push", "origin", job.Branch)
+		output, err := pushCmd.CombinedOutput()
+		if err == nil {
+			return nil
+		}
+		if attempt >= attempts || !isPushRejected(string(output)) {
+			gs.logger.Printf("ERROR: Push failed: %s\n", string(output))
+			return newGitError("git push", output, err)
+		}
+
+		gs.logger.Printf("WARNING: Push to %s was rejected because the remote has new commits, retrying (%d/%d)\n",
+			job.Branch, attempt, attempts-1)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+// isPushRejected 检查推送是否因远程分支有新提交而被拒绝
+func isPushRejected(output string) bool {
+	return strings.Contains(output, "[rejected]") || strings.Contains(output, "non-fast-forward") ||
+		strings.Contains(output, "fetch first")
+}
+
+// rebaseOnRemote 将本地提交变基到远程分支上
+func (gs *GitSync) rebaseOnRemote(user *User, repoPath string, job *Job, owned map[string]bool) error {
+	if !hasRemoteBranch(user, repoPath, job.Branch) {
+		return nil
+	}
+
+	gs.logger.Printf("DEBUG: Rebasing with remote branch: %s\n", job.Branch)
+	output, err := gitCommand(user, repoPath, "rebase", "origin/"+job.Branch).CombinedOutput()
+	for round := 0; err != nil; round++ {
+		if !rebaseInProgress(repoPath) || round >= maxConflictRounds {
+			gs.logger.Printf("ERROR: Rebase failed: %s\n", string(output))
+			if rebaseInProgress(repoPath) {
+				gs.abort(user, repoPath, "rebase")
+			}
+			return newGitError("git rebase", output, err)
+		}
+
+		conflicts, listErr := conflictedPaths(user, repoPath)
+		if listErr != nil {
+			gs.abort(user, repoPath, "rebase")
+			return listErr
+		}
+		if len(conflicts) == 0 {
+			// 解决冲突后提交内容与远程相同，跳过该提交
+			output, err = gitCommand(user, repoPath, "rebase", "--skip").CombinedOutput()
+			continue
+		}
+		if resolveErr := gs.resolveConflicts(user, repoPath, job, conflicts, owned, true); resolveErr != nil {
+			gs.abort(user, repoPath, "rebase")
+			return fmt.Errorf("git rebase failed: %v", resolveErr)
+		}
+
+		cmd := gitCommand(user, repoPath, "rebase", "--continue")
+		cmd.Env = append(cmd.Env, "GIT_EDITOR=true")
+		output, err = cmd.CombinedOutput()
+	}
+	return nil
+}
+
+// mergeRemote 将远程分支合并到本地分支
+func (gs *GitSync) mergeRemote(user *User, repoPath string, job *Job, owned map[string]bool) error {
+	if !hasRemoteBranch(user, repoPath, job.Branch) {
+		return nil
+	}
+
+	gs.logger.Printf("DEBUG: Merging remote branch: %s\n", job.Branch)
+	output, err := gitCommand(user, repoPath, "merge", "--no-edit", "origin/"+job.Branch).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+
+	conflicts, listErr := conflictedPaths(user, repoPath)
+	if listErr != nil || len(conflicts) == 0 {
+		gs.logger.Printf("ERROR: Merge failed: %s\n", string(output))
+		gs.abort(user, repoPath, "merge")
+		return newGitError("git merge", output, err)
+	}
+	if err := gs.resolveConflicts(user, repoPath, job, conflicts, owned, false); err != nil {
+		gs.abort(user, repoPath, "merge")
+		return fmt.Errorf("git merge failed: %v", err)
+	}
+
+	commitCmd := gitCommand(user, repoPath, "commit", "--no-edit")
+	if output, err := commitCmd.CombinedOutput(); err != nil {
+		gs.logger.Printf("ERROR: Merge commit failed: %s\n", string(output))
+		gs.abort(user, repoPath, "merge")
+		return newGitError("git commit", output, err)
+	}
+	return nil
+}
+
+// resolveConflicts 按 on_conflict 解决任务拥有的文件上的冲突
+// 存在不属于任务的冲突文件时返回错误，由调用方中止整合
+func (gs *GitSync) resolveConflicts(user *User, repoPath string, job *Job, conflicts []string, owned map[string]bool, rebasing bool) error {
+	if job.onConflict() == ConflictFail {
+		return fmt.Errorf("conflicts in %s (set on_conflict to ours or theirs to resolve them automatically)",
+			strings.Join(conflicts, ", "))
+	}
+
+	var foreign []string
+	for _, path := range conflicts {
+		if !owned[path] {
+			foreign = append(foreign, path)
+		}
+	}
+	if len(foreign) > 0 {
+		return fmt.Errorf("conflicts in files not owned by job %s: %s", job.Name, strings.Join(foreign, ", "))
+	}
+
+	side := conflictSide(job.onConflict(), rebasing)
+	for _, path := range conflicts {
+		if err := gitCommand(user, repoPath, "checkout", side, "--", path).Run(); err != nil {
+			// 该文件在选定的一方已被删除
+			if output, err := gitCommand(user, repoPath, "rm", "--quiet", "--", path).CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to resolve conflict in %s: %v: %s", path, err, strings.TrimSpace(string(output)))
+			}
+		} else if output, err := gitCommand(user, repoPath, "add", "--", path).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to resolve conflict in %s: %v: %s", path, err, strings.TrimSpace(string(output)))
+		}
+		gs.logger.Printf("Resolved conflict in %s using the %s version\n", path, job.onConflict())
+	}
+	return nil
+}
+
+// conflictSide 返回解决冲突时 git checkout 使用的参数
+// 变基时 --ours 指远程提交，--theirs 指正在重放的本地提交；合并时相反
+func conflictSide(onConflict string, rebasing bool) string {
+	if (onConflict == ConflictOurs) != rebasing {
+		return "--ours"
+	}
+	return "--theirs"
+}
+
+// conflictedPaths 返回存在未解决冲突的文件
+func conflictedPaths(user *User, repoPath string) ([]string, error) {
+	output, err := gitCommand(user, repoPath, "diff", "--name-only", "--diff-filter=U", "-z").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conflicts: %v", err)
+	}
+	var paths []string
+	for _, path := range strings.Split(string(output), "\x00") {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// ownedPaths 返回任务拥有的仓库文件，包括本次同步中删除或移动的文件
+func ownedPaths(job *Job, report *SyncReport) (map[string]bool, error) {
+	index, err := loadIndex(job)
+	if err != nil {
+		return nil, err
+	}
+	owned := make(map[string]bool, len(index.Files))
+	for path := range index.Files {
+		owned[path] = true
+	}
+	if report != nil {
+		for _, path := range report.Removed {
+			owned[path] = true
+		}
+		for _, renamed := range report.Renamed {
+			owned[renamed.From] = true
+		}
+	}
+	return owned, nil
+}
+
+// hasRemoteBranch 检查是否已获取远程分支
+func hasRemoteBranch(user *User, repoPath, branch string) bool {
+	return gitCommand(user, repoPath, "rev-parse", "--verify", "--quiet", "refs/remotes/origin/"+branch).Run() == nil
+}
+
+// rebaseInProgress 检查仓库是否处于变基过程中
+func rebaseInProgress(repoPath string) bool {
+	for _, dir := range []string{"rebase-merge", "rebase-apply"} {
+		if _, err := os.Stat(filepath.Join(repoPath, ".git", dir)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// abort 中止进行中的变基或合并，恢复到整合前的状态
+func (gs *GitSync) abort(user *User, repoPath, operation string) {
+	if output, err := gitCommand(user, repoPath, operation, "--abort").CombinedOutput(); err != nil {
+		gs.logger.Printf("WARNING: Failed to abort %s: %s\n", operation, strings.TrimSpace(string(output)))
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPushChangesConflicts(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	tests := []struct {
+		name       string
+		strategy   string
+		onConflict string
+		owned      bool
+		want       string // 远程库中 a.md 的最终内容，为空表示应失败
+	}{
+		{name: "rebase-ours", strategy: MergeRebase, onConflict: ConflictOurs, owned: true, want: "local"},
+		{name: "rebase-theirs", strategy: MergeRebase, onConflict: ConflictTheirs, owned: true, want: "remote"},
+		{name: "merge-ours", strategy: MergeMerge, onConflict: ConflictOurs, owned: true, want: "local"},
+		{name: "merge-theirs", strategy: MergeMerge, onConflict: ConflictTheirs, owned: true, want: "remote"},
+		{name: "rebase-fail", strategy: MergeRebase, owned: true},
+		{name: "merge-not-owned", strategy: MergeMerge, onConflict: ConflictOurs},
+	}
+
+	user := &User{Username: "Sync Bot", Email: "bot@example.com"}
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		output, err := gitCommand(user, dir, args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	write := func(dir, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, "a.md"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 远程库初始内容
+			remote := filepath.Join(t.TempDir(), "remote.git")
+			other := t.TempDir()
+			git(other, "init", "-b", "main")
+			write(other, "base")
+			git(other, "add", "-A")
+			git(other, "commit", "-m", "base")
+			git(other, "clone", "--bare", other, remote)
+			git(other, "remote", "add", "origin", remote)
+			git(other, "fetch", "origin")
+
+			gs := &GitSync{logger: createTestLogger()}
+			job := &Job{
+				Name:          "test-conflict-" + tt.name,
+				RemoteURL:     "file://" + filepath.ToSlash(remote),
+				MergeStrategy: tt.strategy,
+				OnConflict:    tt.onConflict,
+			}
+			repoPath := job.GetRepoPath()
+			defer os.RemoveAll(repoPath)
+			if err := gs.initRepo(user, job); err != nil {
+				t.Fatalf("initRepo failed: %v", err)
+			}
+
+			// 其他人先推送了修改
+			write(other, "remote")
+			git(other, "commit", "-am", "remote change")
+			git(other, "push", "origin", "HEAD:main")
+
+			write(repoPath, "local")
+			git(repoPath, "commit", "-am", "local change")
+
+			owned := map[string]bool{}
+			if tt.owned {
+				owned["a.md"] = true
+			}
+			err := gs.pushChanges(user, repoPath, job, owned)
+
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("Expected pushChanges to fail")
+				}
+				if rebaseInProgress(repoPath) {
+					t.Errorf("Expected rebase to be aborted")
+				}
+				if _, err := os.Stat(filepath.Join(repoPath, ".git", "MERGE_HEAD")); err == nil {
+					t.Errorf("Expected merge to be aborted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("pushChanges failed: %v", err)
+			}
+			if got := git(remote, "show", "main:a.md"); got != tt.want {
+				t.Errorf("Expected remote a.md %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCommitChangesPushesUnpushedCommits(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	user := &User{Username: "Sync Bot", Email: "bot@example.com"}
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		output, err := gitCommand(user, dir, args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+
+	remote := filepath.Join(t.TempDir(), "remote.git")
+	work := t.TempDir()
+	git(work, "init", "-b", "main")
+	git(work, "commit", "--allow-empty", "-m", "base")
+	git(work, "clone", "--bare", work, remote)
+
+	gs := &GitSync{logger: createTestLogger()}
+	job := &Job{Name: "test-unpushed", RemoteURL: "file://" + filepath.ToSlash(remote)}
+	repoPath := job.GetRepoPath()
+	defer os.RemoveAll(repoPath)
+	defer os.Remove(indexPath(job))
+	if err := gs.initRepo(user, job); err != nil {
+		t.Fatalf("initRepo failed: %v", err)
+	}
+
+	// 上次运行提交成功但推送失败，工作区已干净
+	if err := os.WriteFile(filepath.Join(repoPath, "a.md"), []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(repoPath, "add", "-A")
+	git(repoPath, "commit", "-m", "unpushed")
+
+	changes, err := gs.commitChanges(user, job, &SyncReport{})
+	if err != nil {
+		t.Fatalf("commitChanges failed: %v", err)
+	}
+	if changes != nil {
+		t.Errorf("Expected no new commit, got %+v", changes)
+	}
+	if got := git(remote, "log", "-1", "--format=%s", "main"); got != "unpushed" {
+		t.Errorf("Expected the unpushed commit to be pushed, got %q", got)
+	}
+}
+
+func TestPushChangesRetry(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test hook is a shell script")
+	}
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	tests := []struct {
+		name    string
+		races   int  // 远程在获取和推送之间被其他人更新的次数
+		retries int  // push_retries
+		ok      bool // 是否应推送成功
+	}{
+		{name: "succeeds-on-retry", races: 1, retries: 1, ok: true},
+		{name: "gives-up", races: 3, retries: 1},
+	}
+
+	user := &User{Username: "Sync Bot", Email: "bot@example.com"}
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		output, err := gitCommand(user, dir, args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remote := filepath.Join(t.TempDir(), "remote.git")
+			other := t.TempDir()
+			git(other, "init", "-b", "main")
+			git(other, "commit", "--allow-empty", "-m", "base")
+			git(other, "clone", "--bare", other, remote)
+			git(other, "remote", "add", "origin", remote)
+
+			gs := &GitSync{logger: createTestLogger()}
+			job := &Job{
+				Name:          "test-push-retry-" + tt.name,
+				RemoteURL:     "file://" + filepath.ToSlash(remote),
+				MergeStrategy: MergeRebase,
+				PushRetries:   tt.retries,
+			}
+			repoPath := job.GetRepoPath()
+			defer os.RemoveAll(repoPath)
+			if err := gs.initRepo(user, job); err != nil {
+				t.Fatalf("initRepo failed: %v", err)
+			}
+
+			// 每次获取更新 origin/main 后，其他人抢在推送之前再提交一次
+			counter := filepath.Join(t.TempDir(), "races")
+			hook := fmt.Sprintf(`#!/bin/sh
+[ "$1" = committed ] || exit 0
+grep -q " refs/remotes/origin/main$" || exit 0
+[ "$(wc -l < %[1]q 2>/dev/null || echo 0)" -lt %[2]d ] || exit 0
+echo race >> %[1]q
+unset GIT_DIR GIT_WORK_TREE GIT_INDEX_FILE
+cd %[3]q && git commit -q --allow-empty -m race && git push -q origin HEAD:main
+`, counter, tt.races, other)
+			if err := os.WriteFile(filepath.Join(repoPath, ".git", "hooks", "reference-transaction"), []byte(hook), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			// 远程已有新的提交，第一次获取会更新 origin/main
+			git(other, "commit", "--allow-empty", "-m", "remote change")
+			git(other, "push", "origin", "HEAD:main")
+			if err := os.WriteFile(filepath.Join(repoPath, "a.md"), []byte("local"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			git(repoPath, "add", "-A")
+			git(repoPath, "commit", "-m", "local change")
+
+			err := gs.pushChanges(user, repoPath, job, map[string]bool{"a.md": true})
+			if !tt.ok {
+				if err == nil {
+					t.Fatalf("Expected pushChanges to fail after %d retries", tt.retries)
+				}
+				if got := git(remote, "log", "-1", "--format=%s", "main"); got != "race" {
+					t.Errorf("Expected the remote to keep the racing commit, got %q", got)
+				}
+				// 每次尝试都会重新获取，共 1+push_retries 次
+				data, _ := os.ReadFile(counter)
+				if got := strings.Count(string(data), "race"); got != tt.retries+1 {
+					t.Errorf("Expected %d fetch attempts, got %d", tt.retries+1, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("pushChanges failed: %v", err)
+			}
+			if got := git(remote, "log", "--format=%s", "main"); got != "local change\nrace\nremote change\nbase" {
+				t.Errorf("Unexpected remote history:\n%s", got)
+			}
+		})
+	}
+}
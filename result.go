@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// 同步任务的执行阶段
+const (
+	StageInit    = "init"    // 初始化仓库
+	StagePrepare = "prepare" // 准备拉取请求分支
+	StageSync    = "sync"    // 复制文件
+	StageCommit  = "commit"  // 提交更改
+	StagePush    = "push"    // 推送到远程
+	StagePublish = "publish" // 推送拉取请求分支并创建拉取请求
+)
+
+// SyncResult 一次同步的结果，失败时记录失败的阶段和 git 命令的输出
+type SyncResult struct {
+	Stage    string // 失败的阶段，成功时为空
+	Err      error  // 失败原因
+	ExitCode int    // 失败的 git 命令的退出码，不是 git 命令失败时为 0
+	Stderr   string // 失败的 git 命令的输出
+}
+
+// fail 记录失败的阶段和错误，错误链中包含 GitError 时提取退出码和输出
+func (r *SyncResult) fail(stage string, err error) {
+	r.Stage = stage
+	r.Err = err
+
+	var gitErr *GitError
+	if errors.As(err, &gitErr) {
+		r.ExitCode = gitErr.ExitCode
+		r.Stderr = gitErr.Stderr
+	}
+}
+
+// Status 返回 success 或 failure
+func (r *SyncResult) Status() string {
+	if r.Err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// GitError git 命令执行失败
+type GitError struct {
+	Op       string // 失败的操作，例如 "git push"
+	ExitCode int    // 退出码，命令无法启动时为 -1
+	Stderr   string // 命令的输出
+	Err      error
+}
+
+// newGitError 根据命令的错误和输出创建 GitError
+func newGitError(op string, output []byte, err error) *GitError {
+	gitErr := &GitError{Op: op, ExitCode: -1, Stderr: strings.TrimSpace(string(output)), Err: err}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		gitErr.ExitCode = exitErr.ExitCode()
+		if gitErr.Stderr == "" {
+			gitErr.Stderr = strings.TrimSpace(string(exitErr.Stderr))
+		}
+	}
+	return gitErr
+}
+
+func (e *GitError) Error() string {
+	if e.Stderr != "" {
+		return fmt.Sprintf("%s failed: %v: %s", e.Op, e.Err, e.Stderr)
+	}
+	return fmt.Sprintf("%s failed: %v", e.Op, e.Err)
+}
+
+func (e *GitError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncJobFailureResult(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer server.Close()
+
+	gs := &GitSync{
+		config:         &Config{},
+		logger:         createTestLogger(),
+		webhookManager: NewWebhookManager(createTestLogger()),
+		historyFile:    filepath.Join(t.TempDir(), HistoryFile),
+	}
+	webhook := WebhookConfig{
+		Name:       "failure",
+		URL:        server.URL,
+		Method:     "POST",
+		Trigger:    "failure",
+		RetryCount: 1,
+		Body:       `{{.Status}} {{.Result.Stage}} {{.Result.ExitCode}}`,
+	}
+	if err := gs.webhookManager.RegisterWebhook(webhook); err != nil {
+		t.Fatal(err)
+	}
+
+	// 远程库不存在，初始化阶段的 git ls-remote 失败
+	user := &User{Username: "Sync Bot", Email: "bot@example.com"}
+	job := &Job{
+		Name:       "test-failure-result",
+		SourcePath: t.TempDir(),
+		RemoteURL:  "file://" + filepath.ToSlash(filepath.Join(t.TempDir(), "missing.git")),
+		Branch:     "main",
+		Webhooks:   []string{"failure"},
+	}
+	defer os.RemoveAll(job.GetRepoPath())
+
+	gs.syncJob(user, job)
+
+	if want := "failure init 128"; body != want {
+		t.Errorf("Expected webhook body %q, got %q", want, body)
+	}
+
+	f, err := os.Open(gs.historyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var record RunRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if record.Job != job.Name || record.Status != "failure" || record.Stage != StageInit || record.ExitCode != 128 || record.Stderr == "" {
+		t.Errorf("Unexpected run record: %+v", record)
+	}
+}
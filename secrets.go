@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// secretReference 匹配配置中的引用，例如 ${env:GITHUB_TOKEN}，$${...} 表示字面量
+var secretReference = regexp.MustCompile(`\$?\$\{([a-zA-Z]+):([^}]*)\}`)
+
+// secretFields 保存凭据的字段：git_password、remote_url、forge.token 以及 webhook 的 url 和 headers
+// 只有解析到这些字段中的值才需要从日志中清除，其他字段（例如路径）的值清除后反而会让日志难以阅读
+var secretFields = regexp.MustCompile(`^(users\[\d+\]\.(git_password|jobs\[\d+\]\.(remote_url|forge\.token))|webhooks\[\d+\]\.(url|headers\..+))$`)
+
+// secretCommandTimeout ${cmd:...} 引用的执行超时时间
+const secretCommandTimeout = 30 * time.Second
+
+// resolveReferences 展开配置中所有字符串字段的 ${env:...}、${file:...} 和 ${cmd:...} 引用
+// 返回展开到凭据字段中的值，用于从日志中清除
+func resolveReferences(config *Config) ([]string, error) {
+	var secrets []string
+	if err := resolveValue(reflect.ValueOf(config), "", &secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// resolveValue 递归展开结构体、切片和 map 中的字符串，path 为字段的 YAML 路径
+func resolveValue(v reflect.Value, path string, secrets *[]string) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return resolveValue(v.Elem(), path, secrets)
+
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			if err := resolveValue(v.Field(i), name, secrets); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := resolveValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), secrets); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		for _, key := range v.MapKeys() {
+			keyPath := fmt.Sprintf("%s.%v", path, key.Interface())
+			resolved, err := expandReferences(v.MapIndex(key).String(), keyPath, secrets)
+			if err != nil {
+				return &ConfigError{Path: keyPath, Err: err}
+			}
+			v.SetMapIndex(key, reflect.ValueOf(resolved).Convert(v.Type().Elem()))
+		}
+
+	case reflect.String:
+		if !v.CanSet() {
+			return nil
+		}
+		resolved, err := expandReferences(v.String(), path, secrets)
+		if err != nil {
+			return &ConfigError{Path: path, Err: err}
+		}
+		v.SetString(resolved)
+	}
+	return nil
+}
+
+// expandReferences 展开字符串中的全部引用，path 为凭据字段时记录展开得到的值
+func expandReferences(s, path string, secrets *[]string) (string, error) {
+	var firstErr error
+	expanded := secretReference.ReplaceAllStringFunc(s, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		if firstErr != nil {
+			return ref
+		}
+
+		m := secretReference.FindStringSubmatch(ref)
+		value, err := resolveReference(m[1], m[2])
+		if err != nil {
+			firstErr = fmt.Errorf("unresolved reference %s: %v", ref, err)
+			return ref
+		}
+		if secretFields.MatchString(path) {
+			*secrets = append(*secrets, value)
+		}
+		return value
+	})
+	return expanded, firstErr
+}
+
+// resolveReference 解析单个引用
+func resolveReference(kind, arg string) (string, error) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return "", fmt.Errorf("empty %s reference", kind)
+	}
+
+	switch kind {
+	case "env":
+		value, ok := os.LookupEnv(arg)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", arg)
+		}
+		return value, nil
+
+	case "file":
+		data, err := os.ReadFile(expandHome(arg))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+
+	case "cmd":
+		ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+		defer cancel()
+
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", arg)
+		} else {
+			cmd = exec.CommandContext(ctx, "sh", "-c", arg)
+		}
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return "", fmt.Errorf("command failed: %v: %s", err, msg)
+			}
+			return "", fmt.Errorf("command failed: %v", err)
+		}
+		return strings.TrimRight(string(output), "\r\n"), nil
+
+	default:
+		return "", fmt.Errorf("unknown reference type %q (expected env, file or cmd)", kind)
+	}
+}
@@ -1,2 +1,2 @@
 @echo off
-git-syncer.exe -d -c config.yaml
\ No newline at end of file
+git-syncer.exe run -d -c config.yaml
\ No newline at end of file
@@ -1,7 +1,3 @@
 @echo off
-tasklist | findstr "git-syncer"
-if %ERRORLEVEL% EQU 0 (
-    echo Git-Syncer is running
-) else (
-    echo Git-Syncer is not running
-) 
\ No newline at end of file
+git-syncer.exe status -c config.yaml
+exit /b %ERRORLEVEL%
\ No newline at end of file
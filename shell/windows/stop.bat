@@ -1,5 +1,2 @@
 @echo off
-for /f "tokens=2" %%a in ('tasklist ^| findstr "git-syncer"') do (
-    taskkill /F /PID %%a
-)
-if exist git-syncer.pid del git-syncer.pid 
\ No newline at end of file
+git-syncer.exe stop
\ No newline at end of file
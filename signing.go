@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// 提交签名格式
+const (
+	SigningOpenPGP = "openpgp" // GPG 密钥 ID 或指纹（默认）
+	SigningSSH     = "ssh"     // SSH 密钥文件路径
+)
+
+// signingFormat 返回用户的签名格式
+func (u *User) signingFormat() string {
+	if u.SigningFormat == "" {
+		return SigningOpenPGP
+	}
+	return strings.ToLower(u.SigningFormat)
+}
+
+// signingKey 返回 git 使用的签名密钥，SSH 密钥路径支持 ~
+func (u *User) signingKey() string {
+	if u.signingFormat() == SigningSSH {
+		return expandHome(u.SigningKey)
+	}
+	return u.SigningKey
+}
+
+// signingConfig 返回启用提交签名所需的 git 配置项，未配置 signing_key 时返回 nil
+func (u *User) signingConfig() [][2]string {
+	if u.SigningKey == "" {
+		return nil
+	}
+	return [][2]string{
+		{"gpg.format", u.signingFormat()},
+		{"user.signingkey", u.signingKey()},
+		{"commit.gpgsign", "true"},
+	}
+}
+
+// checkSigningKey 检查签名密钥是否可用
+func (u *User) checkSigningKey() error {
+	if u.SigningKey == "" {
+		if u.SigningFormat != "" {
+			return fmt.Errorf("signing_format is set but signing_key is empty")
+		}
+		return nil
+	}
+
+	var cmd *exec.Cmd
+	switch u.signingFormat() {
+	case SigningOpenPGP:
+		cmd = exec.Command("gpg", "--batch", "--list-secret-keys", u.SigningKey)
+	case SigningSSH:
+		if _, err := os.Stat(u.signingKey()); err != nil {
+			return fmt.Errorf("ssh signing key is not accessible: %v", err)
+		}
+		cmd = exec.Command("ssh-keygen", "-l", "-f", u.signingKey())
+	default:
+		return fmt.Errorf("invalid signing_format: %s", u.SigningFormat)
+	}
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s signing key %s is not usable: %v: %s", u.signingFormat(), u.SigningKey, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// isSigningFailure 检查 git commit 的输出是否表示签名失败
+func isSigningFailure(output string) bool {
+	return strings.Contains(output, "failed to sign") || strings.Contains(output, "failed to write commit object")
+}
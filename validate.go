@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigError 配置中某个字段的错误，Line 为该字段在配置文件中的行号
+type ConfigError struct {
+	Path string // 字段的 YAML 路径，例如 users[0].jobs[1].schedule
+	Line int    // 行号，未知时为 0
+	Err  error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %v", e.Line, e.Path, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// FieldError 用户或任务配置中某个字段的错误，Field 为相对该用户或任务的 YAML 路径
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// errSourceNotFound 源路径不存在。启动时只作为警告，例如尚未挂载的共享目录
+var errSourceNotFound = errors.New("source path does not exist")
+
+// fieldErrorf 创建指向某个字段的错误
+func fieldErrorf(field, format string, args ...interface{}) error {
+	return &FieldError{Field: field, Err: fmt.Errorf(format, args...)}
+}
+
+// configLines 记录 YAML 节点下每个字段的行号，路径格式与 ConfigError.Path 相同
+func configLines(node *yaml.Node, path string, lines map[string]int) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			configLines(child, path, lines)
+		}
+	case yaml.AliasNode:
+		configLines(node.Alias, path, lines)
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			child := key.Value
+			if path != "" {
+				child = path + "." + key.Value
+			}
+			lines[child] = key.Line
+			configLines(value, child, lines)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			child := fmt.Sprintf("%s[%d]", path, i)
+			lines[child] = item.Line
+			configLines(item, child, lines)
+		}
+	}
+}
+
+// line 返回字段所在的行号，字段未出现在配置文件中时使用最近的上级字段
+func (c *Config) line(path string) int {
+	for path != "" {
+		if line, ok := c.lines[path]; ok {
+			return line
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return 0
+}
+
+// configError 为 path 下的错误创建带行号的 ConfigError，FieldError 的字段会追加到路径中
+func (c *Config) configError(path string, err error) *ConfigError {
+	if fieldErr, ok := err.(*FieldError); ok {
+		path += "." + fieldErr.Field
+		err = fieldErr.Err
+	}
+	return &ConfigError{Path: path, Line: c.line(path), Err: err}
+}
+
+// validateSchedule 检查任务的触发方式和 cron 表达式
+func validateSchedule(job *Job) error {
+	switch job.Trigger {
+	case "", TriggerCron, TriggerWatch:
+	default:
+		return fieldErrorf("trigger", "invalid trigger: %s", job.Trigger)
+	}
+	if job.Schedule == "" {
+		if job.Trigger == TriggerWatch {
+			return nil
+		}
+		return fieldErrorf("schedule", "schedule is required unless trigger is %s", TriggerWatch)
+	}
+	// 与调度器使用相同的解析规则
+	if _, err := cron.ParseStandard(job.Schedule); err != nil {
+		return fieldErrorf("schedule", "invalid cron expression %q: %v", job.Schedule, err)
+	}
+	return nil
+}
+
+// validateConfig 检查全部用户、任务和 webhook 的配置，返回按行号排序的全部问题
+func validateConfig(config *Config) []error {
+	var errs []*ConfigError
+	add := func(path string, err error) {
+		errs = append(errs, config.configError(path, err))
+	}
+
+	webhooks := make(map[string]bool)
+	wm := NewWebhookManager(log.New(io.Discard, "", 0))
+	for i, webhook := range config.Webhooks {
+		path := fmt.Sprintf("webhooks[%d]", i)
+		if err := wm.RegisterWebhook(webhook); err != nil {
+			add(path, err)
+			continue
+		}
+		if webhooks[webhook.Name] {
+			add(path+".name", fmt.Errorf("duplicate webhook name %s", webhook.Name))
+		}
+		webhooks[webhook.Name] = true
+	}
+
+	workDir, _ := os.Getwd()
+	repos := make(map[string]string) // 任务目录 -> 第一个使用该目录的任务路径
+	gs := &GitSync{}
+	for i := range config.Users {
+		user := &config.Users[i]
+		userPath := fmt.Sprintf("users[%d]", i)
+		if err := validateUser(user); err != nil {
+			add(userPath, err)
+		}
+
+		for j := range user.Jobs {
+			job := &user.Jobs[j]
+			path := fmt.Sprintf("%s.jobs[%d]", userPath, j)
+
+			// 任务名决定 .git-syncer 下的仓库目录，清理后相同的名称会共用同一个仓库
+			dir := sanitizePath(job.Name)
+			if strings.TrimSpace(job.Name) == "" {
+				add(path+".name", fmt.Errorf("job name is required"))
+			} else if first, ok := repos[dir]; ok {
+				add(path+".name", fmt.Errorf("job %s uses the same directory %s as the job at line %d",
+					job.Name, filepath.Join(GitSyncerDir, dir), config.line(first+".name")))
+			} else {
+				repos[dir] = path
+			}
+
+			if err := validateSchedule(job); err != nil {
+				add(path, err)
+			}
+			if err := gs.validateJob(job); err != nil {
+				add(path, err)
+			}
+			for k, name := range job.Webhooks {
+				if !webhooks[name] {
+					add(fmt.Sprintf("%s.webhooks[%d]", path, k), fmt.Errorf("unknown webhook %s", name))
+				}
+			}
+
+			for k, src := range job.GetSources() {
+				if strings.TrimSpace(src.Path) == "" {
+					continue
+				}
+				root, _ := src.root(workDir)
+				if _, err := os.Stat(root); err != nil {
+					field := "source_path"
+					if len(job.Sources) > 0 {
+						field = fmt.Sprintf("sources[%d].path", k)
+					}
+					add(path, &FieldError{Field: field, Err: fmt.Errorf("%w: %s", errSourceNotFound, root)})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Line < errs[j].Line
+	})
+	result := make([]error, len(errs))
+	for i, err := range errs {
+		result[i] = err
+	}
+	return result
+}
+
+// jobConfigErrors 返回 validateConfig 的结果中属于 jobPath 指向的任务的错误
+// 源路径不存在不妨碍启动任务，作为警告单独返回
+func jobConfigErrors(errs []error, jobPath string) (fatal, warnings []error) {
+	for _, err := range errs {
+		configErr, ok := err.(*ConfigError)
+		if !ok || (configErr.Path != jobPath && !strings.HasPrefix(configErr.Path, jobPath+".")) {
+			continue
+		}
+		if errors.Is(configErr.Err, errSourceNotFound) {
+			warnings = append(warnings, err)
+		} else {
+			fatal = append(fatal, err)
+		}
+	}
+	return fatal, warnings
+}
+
+// checkJobConfig 输出任务的配置问题，存在错误时返回 false
+func (gs *GitSync) checkJobConfig(errs []error, jobPath string, job *Job) bool {
+	fatal, warnings := jobConfigErrors(errs, jobPath)
+	for _, err := range warnings {
+		gs.logger.Printf("WARNING: Job %s: %v\n", job.Name, err)
+	}
+	for _, err := range fatal {
+		gs.logger.Printf("ERROR: Invalid config for job %s: %v\n", job.Name, err)
+	}
+	return len(fatal) == 0
+}
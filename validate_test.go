@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigUnknownField(t *testing.T) {
+	path := writeTestConfig(t, `users:
+  - username: "Sync Bot"
+    email: "bot@example.com"
+    jobs:
+      - name: "docs"
+        repo_path: "./repo"
+`)
+	_, err := loadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), "line 6: field repo_path not found") {
+		t.Errorf("Expected an unknown field error on line 6, got %v", err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	source := t.TempDir()
+	missing := filepath.Join(source, "missing")
+	path := writeTestConfig(t, `users:
+  - username: "Sync Bot"
+    email: "bot@example.com"
+    jobs:
+      - name: "my docs"
+        schedule: "*/5 * * * *"
+        source_path: "`+filepath.ToSlash(source)+`"
+        webhooks: ["notify", "unknown"]
+        on_collision: prefx
+      - name: "my/docs"
+        schedule: "every minute"
+        source_path: "`+filepath.ToSlash(missing)+`"
+        remote_path: "docs"
+        keep_structure: true
+      - name: "watched"
+        trigger: watch
+        symlinks: folow
+        sources:
+          - path: "`+filepath.ToSlash(source)+`"
+  - username: "no-email"
+webhooks:
+  - name: "notify"
+    url: "https://example.com/hook"
+  - name: "broken"
+`)
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"line 8: users[0].jobs[0].webhooks[1]: unknown webhook unknown",
+		"line 9: users[0].jobs[0].on_collision: invalid on_collision: prefx",
+		"line 10: users[0].jobs[1].name: job my/docs uses the same directory " + filepath.Join(GitSyncerDir, "my-docs") + " as the job at line 5",
+		"line 11: users[0].jobs[1].schedule: invalid cron expression \"every minute\"",
+		"line 12: users[0].jobs[1].source_path: source path does not exist: " + filepath.FromSlash(missing),
+		"line 14: users[0].jobs[1].keep_structure: remote_path and keep_structure cannot be used together",
+		"line 17: users[0].jobs[2].symlinks: invalid symlinks policy: folow",
+		"line 20: users[1].email: username and email are required",
+		"line 24: webhooks[1]: webhook URL cannot be empty",
+	}
+	errs := validateConfig(config)
+	if len(errs) != len(want) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(want), len(errs), errs)
+	}
+	for i, err := range errs {
+		if !strings.HasPrefix(err.Error(), want[i]) {
+			t.Errorf("Expected error %q, got %q", want[i], err)
+		}
+	}
+
+	user, job, jobPath := config.findJob("watched")
+	if job == nil || job != &config.Users[0].Jobs[2] || user != &config.Users[0] || jobPath != "users[0].jobs[2]" {
+		t.Errorf("findJob returned %v, %v, %q", user, job, jobPath)
+	}
+	if _, job, _ := config.findJob("missing"); job != nil {
+		t.Errorf("Expected no job, got %v", job)
+	}
+
+	// 启动时只检查要运行的任务，源路径不存在只是警告
+	if fatal, warnings := jobConfigErrors(errs, "users[0].jobs[2]"); len(fatal) != 1 || len(warnings) != 0 {
+		t.Errorf("Expected the symlinks error only, got %v %v", fatal, warnings)
+	}
+	if fatal, warnings := jobConfigErrors(errs, "users[0].jobs[0]"); len(fatal) != 2 || len(warnings) != 0 {
+		t.Errorf("Expected the on_collision and unknown webhook errors, got %v %v", fatal, warnings)
+	}
+	if fatal, warnings := jobConfigErrors(errs, "users[0].jobs[1]"); len(fatal) != 3 || len(warnings) != 1 {
+		t.Errorf("Expected 3 errors and 1 warning, got %v %v", fatal, warnings)
+	}
+}
+
+func TestLoadConfigBooleans(t *testing.T) {
+	path := writeTestConfig(t, `users:
+  - username: "Sync Bot"
+    email: "bot@example.com"
+    jobs:
+      - name: "docs"
+        keep_structure: yes
+        mirror: on
+        preserve_times: True
+        signoff: no
+`)
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := config.Users[0].Jobs[0]
+	if !job.KeepStructure || !job.Mirror || !job.PreserveTimes || job.Signoff {
+		t.Errorf("Expected YAML 1.1 booleans to be accepted, got %+v", job)
+	}
+}
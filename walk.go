@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// 符号链接处理策略
+const (
+	SymlinksFollow   = "follow"   // 跟随链接，同步链接指向的内容（默认）
+	SymlinksPreserve = "preserve" // 将链接本身提交到仓库
+	SymlinksSkip     = "skip"     // 忽略所有符号链接
+)
+
+// symlinkPolicy 返回任务的符号链接策略
+func (j *Job) symlinkPolicy() string {
+	if j.Symlinks == "" {
+		return SymlinksFollow
+	}
+	return j.Symlinks
+}
+
+// sourceWalker 遍历源目录时的状态
+type sourceWalker struct {
+	gs          *GitSync
+	job         *Job
+	index       int
+	source      Source
+	workDir     string
+	root        string
+	absolute    bool
+	pattern     string
+	ignoreFiles []string
+	filters     map[string]*Filter // 每个目录使用合并了其自身及上级忽略文件的过滤器
+	active      map[string]bool    // 当前遍历路径上目录的真实路径，用于检测符号链接循环
+	report      *SyncReport
+	files       []sourceFile
+}
+
+// collectFiles 遍历源目录，返回匹配源路径并通过 includes/excludes 过滤的文件
+// index 为源路径在任务 sources 中的序号
+func (gs *GitSync) collectFiles(job *Job, index int, src Source, report *SyncReport) ([]sourceFile, error) {
+	// Get absolute path of working directory
+	workDir, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get working directory: %v", err)
+	}
+
+	// Normalize matching pattern
+	pattern := normalizeSourcePath(src.Path)
+	gs.logger.Printf("DEBUG: Using pattern: %s\n", pattern)
+
+	root, absolute := src.root(workDir)
+	// 源目录不存在时直接失败，避免镜像模式误删全部文件
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, fmt.Errorf("source path is not accessible: %v", err)
+	}
+
+	switch job.symlinkPolicy() {
+	case SymlinksFollow, SymlinksPreserve, SymlinksSkip:
+	default:
+		return nil, fmt.Errorf("invalid symlinks policy: %s", job.Symlinks)
+	}
+
+	w := &sourceWalker{
+		gs:          gs,
+		job:         job,
+		index:       index,
+		source:      src,
+		workDir:     workDir,
+		root:        root,
+		absolute:    absolute,
+		pattern:     pattern,
+		ignoreFiles: job.ignoreFiles(),
+		active:      map[string]bool{realRoot: true},
+		report:      report,
+	}
+
+	rootRules, err := loadIgnoreRules(root, "", w.ignoreFiles)
+	if err != nil {
+		gs.logger.Printf("WARNING: Failed to read ignore files in %s: %v\n", root, err)
+	}
+	w.filters = map[string]*Filter{"": NewFilter(src.Includes, src.Excludes).withRules(rootRules)}
+
+	w.walkDir(root, "")
+	return w.files, nil
+}
+
+// walkDir 遍历目录中的条目，srcRel 为目录相对源目录的路径
+func (w *sourceWalker) walkDir(dir, srcRel string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		w.gs.logger.Printf("WARNING: Failed to read directory %s: %v\n", dir, err)
+		return
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		rel := entry.Name()
+		if srcRel != "" {
+			rel = srcRel + "/" + rel
+		}
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			w.gs.logger.Printf("WARNING: Failed to access path %s: %v\n", path, err)
+			continue
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			switch w.job.symlinkPolicy() {
+			case SymlinksSkip:
+				w.gs.logger.Printf("DEBUG: [%s] skipping symlink %s\n", w.job.Name, rel)
+				continue
+			case SymlinksPreserve:
+				if link, err = os.Readlink(path); err != nil {
+					w.gs.logger.Printf("WARNING: Failed to read symlink %s: %v\n", path, err)
+					continue
+				}
+				// 绝对路径在其他机器上通常不存在，提交到仓库没有意义
+				if filepath.IsAbs(link) || filepath.VolumeName(link) != "" {
+					w.gs.logger.Printf("WARNING: [%s] skipping symlink %s with absolute target %s, only relative links can be preserved\n", w.job.Name, rel, link)
+					continue
+				}
+				if _, err := os.Stat(path); err != nil {
+					w.dangling(rel, link)
+				}
+			default:
+				target, err := os.Stat(path)
+				if err != nil {
+					dest, _ := os.Readlink(path)
+					w.dangling(rel, dest)
+					continue
+				}
+				info = target
+			}
+		}
+
+		if info.IsDir() {
+			w.visitDir(path, rel)
+			continue
+		}
+		w.visitFile(path, rel, info, link)
+	}
+}
+
+// visitDir 根据过滤规则决定是否进入子目录
+func (w *sourceWalker) visitDir(path, rel string) {
+	filter := nearestFilter(w.filters, rel)
+	if decision := filter.Match(rel, true); !decision.Included {
+		w.gs.logger.Printf("DEBUG: [%s] skipping directory %s (excluded by %s)\n", w.job.Name, rel, decision.Reason())
+		return
+	}
+
+	// 跟随符号链接时，同一真实目录出现在当前路径上即为循环
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		w.gs.logger.Printf("WARNING: Failed to resolve directory %s: %v\n", path, err)
+		return
+	}
+	if w.active[realPath] {
+		w.gs.logger.Printf("WARNING: [%s] symlink loop detected at %s, skipping\n", w.job.Name, rel)
+		return
+	}
+	w.active[realPath] = true
+	defer delete(w.active, realPath)
+
+	rules, err := loadIgnoreRules(path, rel, w.ignoreFiles)
+	if err != nil {
+		w.gs.logger.Printf("WARNING: Failed to read ignore files in %s: %v\n", path, err)
+	}
+	if len(rules) > 0 {
+		w.filters[rel] = filter.withRules(rules)
+	}
+
+	w.walkDir(path, rel)
+}
+
+// visitFile 检查文件是否匹配 source_path 和过滤规则
+func (w *sourceWalker) visitFile(path, rel string, info os.FileInfo, link string) {
+	// 忽略文件本身不同步
+	if info.Name() == SyncIgnoreFile {
+		return
+	}
+
+	// Convert to relative path
+	relPath, err := filepath.Rel(w.workDir, path)
+	if err != nil {
+		w.gs.logger.Printf("WARNING: Cannot get relative path for %s: %v\n", path, err)
+		return
+	}
+	// Convert to forward slash path for matching
+	relPath = filepath.ToSlash(relPath)
+
+	// Check if matches pattern
+	target := relPath
+	if w.absolute {
+		target = filepath.ToSlash(path)
+	}
+	matched, err := doublestar.Match(w.pattern, target)
+	if err != nil {
+		w.gs.logger.Printf("WARNING: Pattern matching failed for %s: %v\n", target, err)
+		return
+	}
+	if !matched {
+		return
+	}
+
+	decision := nearestFilter(w.filters, rel).Match(rel, false)
+	w.report.Decisions = append(w.report.Decisions, FileDecision{Source: w.source.Path, Path: rel, Included: decision.Included, Reason: decision.Reason()})
+	if !decision.Included {
+		w.gs.logger.Printf("DEBUG: [%s] excluded %s (%s)\n", w.job.Name, rel, decision.Reason())
+		return
+	}
+
+	w.gs.logger.Printf("DEBUG: [%s] included %s (%s)\n", w.job.Name, rel, decision.Reason())
+	w.files = append(w.files, sourceFile{
+		path:    path,
+		relPath: relPath,
+		srcRel:  rel,
+		source:  w.index,
+		prefix:  w.source.Prefix,
+		info:    info,
+		link:    link,
+	})
+}
+
+// dangling 记录指向不存在目标的符号链接
+func (w *sourceWalker) dangling(rel, target string) {
+	w.gs.logger.Printf("WARNING: [%s] dangling symlink %s -> %s\n", w.job.Name, rel, target)
+	w.report.Dangling = append(w.report.Dangling, rel)
+}
+
+// nearestFilter 返回路径所在最近目录的过滤器
+func nearestFilter(filters map[string]*Filter, srcRel string) *Filter {
+	for dir := parentDir(srcRel); dir != ""; dir = parentDir(dir) {
+		if f, ok := filters[dir]; ok {
+			return f
+		}
+	}
+	return filters[""]
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+)
+
+func TestCollectFilesSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require extra privileges on Windows")
+	}
+
+	sourcePath := t.TempDir()
+	outside := t.TempDir()
+	createTestFile(t, filepath.Join(sourcePath, "a.txt"), "a")
+	createTestFile(t, filepath.Join(outside, "b.txt"), "b")
+	relOutside, err := filepath.Rel(sourcePath, outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSymlink(t, relOutside, filepath.Join(sourcePath, "linked"))
+	mustSymlink(t, ".", filepath.Join(sourcePath, "loop"))
+	mustSymlink(t, "missing.txt", filepath.Join(sourcePath, "dangling.txt"))
+	// preserve 不提交指向绝对路径的链接
+	mustSymlink(t, filepath.Join(outside, "b.txt"), filepath.Join(sourcePath, "absolute.txt"))
+
+	tests := []struct {
+		policy   string
+		want     []string
+		dangling int
+	}{
+		{policy: SymlinksFollow, want: []string{"a.txt", "absolute.txt", "linked/b.txt"}, dangling: 1},
+		{policy: SymlinksPreserve, want: []string{"a.txt", "dangling.txt", "linked", "loop"}, dangling: 1},
+		{policy: SymlinksSkip, want: []string{"a.txt"}, dangling: 0},
+	}
+
+	gs := &GitSync{logger: createTestLogger()}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			report := &SyncReport{}
+			job := &Job{SourcePath: sourcePath, Symlinks: tt.policy}
+			files, err := gs.collectFiles(job, 0, job.GetSources()[0], report)
+			if err != nil {
+				t.Fatalf("collectFiles failed: %v", err)
+			}
+
+			var got []string
+			for _, file := range files {
+				rel, _ := filepath.Rel(sourcePath, file.path)
+				got = append(got, filepath.ToSlash(rel))
+			}
+			sort.Strings(got)
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected files %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Expected files %v, got %v", tt.want, got)
+					break
+				}
+			}
+			if len(report.Dangling) != tt.dangling {
+				t.Errorf("Expected %d dangling links, got %v", tt.dangling, report.Dangling)
+			}
+		})
+	}
+}
+
+func mustSymlink(t *testing.T, target, link string) {
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+}
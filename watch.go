@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// 任务触发方式
+const (
+	TriggerCron  = "cron"  // 按 schedule 定时执行（默认）
+	TriggerWatch = "watch" // 监听源目录变化后执行
+
+	// DefaultDebounce 监听模式下默认的静默期（秒）
+	DefaultDebounce = 2
+)
+
+// watchJob 监听任务的源目录，文件变化后等待静默期结束再执行同步
+func (gs *GitSync) watchJob(user *User, job *Job) error {
+	workDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %v", err)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %v", err)
+	}
+
+	var roots []watchRoot
+	for _, src := range job.GetSources() {
+		root, _ := src.root(workDir)
+		wr := watchRoot{path: root, filter: NewFilter(src.Includes, src.Excludes), ignoreFiles: job.ignoreFiles()}
+		if err := gs.addWatchTree(watcher, wr, root, workDir); err != nil {
+			watcher.Close()
+			return err
+		}
+		roots = append(roots, wr)
+	}
+
+	debounce := time.Duration(job.Debounce) * time.Second
+	if debounce <= 0 {
+		debounce = DefaultDebounce * time.Second
+	}
+
+	go gs.watchLoop(watcher, user, job, roots, workDir, debounce)
+
+	for _, wr := range roots {
+		gs.logger.Printf("Watching %s for job: %s (debounce: %s)\n", wr.path, job.Name, debounce)
+	}
+	return nil
+}
+
+// watchLoop 处理文件变化事件，最后一次变化后静默 debounce 才执行同步，watcher 关闭后返回
+// 被过滤规则排除的文件和 git-syncer 自身写入的文件不会触发同步
+func (gs *GitSync) watchLoop(watcher *fsnotify.Watcher, user *User, job *Job, roots []watchRoot, workDir string, debounce time.Duration) {
+	defer watcher.Close()
+
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if ownFile(event.Name, workDir) {
+				continue
+			}
+
+			// 删除的文件无法判断类型，按文件处理
+			isDir := false
+			if info, err := os.Stat(event.Name); err == nil {
+				isDir = info.IsDir()
+			}
+
+			matched := false
+			for _, wr := range roots {
+				if !isWithin(event.Name, wr.path) || !wr.matches(event.Name, isDir) {
+					continue
+				}
+				matched = true
+				// 新建的目录需要加入监听
+				if isDir && event.Has(fsnotify.Create) {
+					if err := gs.addWatchTree(watcher, wr, event.Name, workDir); err != nil {
+						gs.logger.Printf("WARNING: Failed to watch %s: %v\n", event.Name, err)
+					}
+				}
+			}
+			if !matched {
+				continue
+			}
+
+			gs.logger.Printf("DEBUG: [%s] detected %s on %s\n", job.Name, event.Op, event.Name)
+			timer.Reset(debounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			gs.logger.Printf("WARNING: Watcher error for job %s: %v\n", job.Name, err)
+		case <-timer.C:
+			gs.logger.Printf("Changes settled for job: %s, starting sync\n", job.Name)
+			gs.runJob(user, job)
+		}
+	}
+}
+
+// watchRoot 一个被监听的源目录及其过滤规则
+type watchRoot struct {
+	path        string
+	filter      *Filter
+	ignoreFiles []string // 各级目录中读取的忽略文件，与 collectFiles 相同
+}
+
+// matches 检查源目录下的 path 是否通过过滤规则，沿途目录中的忽略文件同样生效
+func (wr watchRoot) matches(path string, isDir bool) bool {
+	rel, err := filepath.Rel(wr.path, path)
+	if err != nil || rel == "." {
+		return true
+	}
+	rel = filepath.ToSlash(rel)
+
+	filter := wr.filter
+	parts := strings.Split(rel, "/")
+	base := ""
+	for i := 0; i < len(parts); i++ {
+		dir := filepath.Join(wr.path, filepath.FromSlash(base))
+		if rules, err := loadIgnoreRules(dir, base, wr.ignoreFiles); err == nil {
+			filter = filter.withRules(rules)
+		}
+		if base != "" && !filter.Match(base, true).Included {
+			return false
+		}
+		if base == "" {
+			base = parts[i]
+		} else {
+			base += "/" + parts[i]
+		}
+	}
+	// 忽略文件本身的修改会改变同步的文件集合
+	for _, name := range wr.ignoreFiles {
+		if parts[len(parts)-1] == name {
+			return true
+		}
+	}
+	return filter.Match(rel, isDir).Included
+}
+
+// ownFile 检查 path 是否为 git-syncer 自身写入的文件：同步仓库目录、日志和 pid 文件
+// 源目录包含工作目录时，这些文件的变化会不断触发同步
+func ownFile(path, workDir string) bool {
+	if isWithin(path, filepath.Join(workDir, GitSyncerDir)) {
+		return true
+	}
+	for _, name := range []string{LogFile, DaemonLogFile, PidFile} {
+		if path == filepath.Join(workDir, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// addWatchTree 递归监听目录，跳过被排除的目录和同步仓库目录
+func (gs *GitSync) addWatchTree(watcher *fsnotify.Watcher, root watchRoot, dir, workDir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			gs.logger.Printf("WARNING: Failed to access path %s: %v\n", path, err)
+			return nil
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if ownFile(path, workDir) || !root.matches(path, true) {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(path); err != nil {
+			return fmt.Errorf("failed to watch %s: %v", path, err)
+		}
+		return nil
+	})
+}
+
+// isWithin 检查 path 是否为 dir 本身或位于 dir 之下
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
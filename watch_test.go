@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestAddWatchTree(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"docs/guide", "node_modules/pkg", GitSyncerDir + "/job"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	gs := &GitSync{logger: createTestLogger()}
+	wr := watchRoot{path: root, filter: NewFilter(nil, []string{"node_modules/"})}
+	if err := gs.addWatchTree(watcher, wr, root, root); err != nil {
+		t.Fatalf("addWatchTree failed: %v", err)
+	}
+
+	watched := make(map[string]bool)
+	for _, path := range watcher.WatchList() {
+		rel, _ := filepath.Rel(root, path)
+		watched[filepath.ToSlash(rel)] = true
+	}
+
+	for path, want := range map[string]bool{
+		".":                   true,
+		"docs":                true,
+		"docs/guide":          true,
+		"node_modules":        false,
+		"node_modules/pkg":    false,
+		GitSyncerDir:          false,
+		GitSyncerDir + "/job": false,
+	} {
+		if watched[path] != want {
+			t.Errorf("Expected %s watched=%v, got %v", path, want, watched[path])
+		}
+	}
+}
+
+func TestWatchLoopDebounce(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	source := t.TempDir()
+	job := &Job{
+		Name:       "watch-debounce-test",
+		SourcePath: source,
+		RemoteURL:  "file://" + filepath.ToSlash(filepath.Join(t.TempDir(), "missing.git")),
+		Trigger:    TriggerWatch,
+	}
+	defer os.RemoveAll(job.GetRepoPath())
+	defer os.Remove(indexPath(job))
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	// 每次执行 runJob 都会写入一条运行记录
+	history := filepath.Join(t.TempDir(), HistoryFile)
+	gs := &GitSync{logger: createTestLogger(), config: &Config{}, historyFile: history}
+	if err := os.Mkdir(filepath.Join(source, "drafts"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	createTestFile(t, filepath.Join(source, "drafts", SyncIgnoreFile), "*.md\n")
+	wr := watchRoot{path: source, filter: NewFilter(nil, []string{"*.tmp"}), ignoreFiles: job.ignoreFiles()}
+	if err := gs.addWatchTree(watcher, wr, source, source); err != nil {
+		t.Fatal(err)
+	}
+
+	debounce := 500 * time.Millisecond
+	done := make(chan struct{})
+	go func() {
+		gs.watchLoop(watcher, &User{Username: "test", Email: "test@example.com"}, job, []watchRoot{wr}, source, debounce)
+		close(done)
+	}()
+
+	// 被排除的文件和日志文件不触发同步（源目录即工作目录）
+	createTestFile(t, filepath.Join(source, "swap.tmp"), "swap")
+	createTestFile(t, filepath.Join(source, "drafts", "draft.md"), "draft")
+	createTestFile(t, filepath.Join(source, LogFile), "log line")
+	time.Sleep(2 * debounce)
+	if records, err := readHistory(history); err != nil || len(records) != 0 {
+		t.Fatalf("Expected excluded files not to trigger a sync, got %d runs (%v)", len(records), err)
+	}
+
+	// 一连串间隔小于静默期的写入只触发一次同步
+	var lastWrite time.Time
+	for i := 0; i < 6; i++ {
+		if i > 0 {
+			time.Sleep(debounce / 5)
+		}
+		createTestFile(t, filepath.Join(source, "note.txt"), fmt.Sprintf("version %d", i))
+		lastWrite = time.Now()
+	}
+
+	var records []RunRecord
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if records, err = readHistory(history); err != nil {
+			t.Fatal(err)
+		}
+		if len(records) > 0 {
+			break
+		}
+	}
+	if len(records) == 0 {
+		t.Fatal("Expected runJob to run after the quiet period")
+	}
+	if records[0].Start.Before(lastWrite.Add(debounce)) {
+		t.Errorf("runJob started at %v, before the quiet period after the last write at %v", records[0].Start, lastWrite)
+	}
+
+	// 静默期之后不再有新的同步
+	time.Sleep(2 * debounce)
+	watcher.Close()
+	<-done
+	if records, err = readHistory(history); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Errorf("Expected runJob to run exactly once, got %d runs", len(records))
+	}
+}
@@ -32,13 +32,17 @@ type WebhookContext struct {
 	StartTime    string
 	EndTime      string
 	Duration     string
-	ChangedFiles []string
+	ChangedFiles []string      // 本次提交变化的文件，重命名的文件使用新路径
+	Changes      ChangeSummary // 本次提交的变化明细、提交 SHA、分支和远程地址
+	Collisions   []Collision   // 平铺目录时文件名冲突的源文件
+	Result       SyncResult    // 同步结果，失败时包含阶段、退出码和 git 输出
 }
 
 // WebhookManager webhook管理器
 type WebhookManager struct {
 	webhooks map[string]*WebhookConfig
 	logger   *log.Logger
+	redactor *Redactor // 清除错误信息中的认证信息
 }
 
 // NewWebhookManager 创建webhook管理器
@@ -115,8 +119,14 @@ func (wm *WebhookManager) executeWebhookWithReferences(webhook *WebhookConfig, c
 
 // executeWebhook 执行单个webhook
 func (wm *WebhookManager) executeWebhook(webhook *WebhookConfig, ctx WebhookContext) error {
+	ctx.User = redactUser(ctx.User)
+	ctx.Job = redactJob(ctx.Job)
+	ctx.Error = wm.redactor.RedactError(ctx.Error)
+	ctx.Result.Err = wm.redactor.RedactError(ctx.Result.Err)
+	ctx.Result.Stderr = wm.redactor.Redact(ctx.Result.Stderr)
+
 	// 解析body模板
-	bodyTemplate, err := template.New("webhook_body").Parse(webhook.Body)
+	bodyTemplate, err := template.New("webhook_body").Funcs(templateFuncs).Parse(webhook.Body)
 	if err != nil {
 		return fmt.Errorf("failed to parse webhook body template: %v", err)
 	}
@@ -127,10 +137,13 @@ func (wm *WebhookManager) executeWebhook(webhook *WebhookConfig, ctx WebhookCont
 		return fmt.Errorf("failed to execute webhook body template: %v", err)
 	}
 
+	// 模板可能引用了其他字段中的敏感信息
+	body := wm.redactor.Redact(bodyBuffer.String())
+
 	// 重试逻辑
 	var lastErr error
 	for i := 0; i < webhook.RetryCount; i++ {
-		if err := wm.sendWebhookRequest(webhook, bodyBuffer.String()); err != nil {
+		if err := wm.sendWebhookRequest(webhook, body); err != nil {
 			lastErr = err
 			wm.logger.Printf("Webhook attempt %d failed: %v", i+1, err)
 			time.Sleep(time.Duration(webhook.RetryDelay) * time.Second)
@@ -142,6 +155,24 @@ func (wm *WebhookManager) executeWebhook(webhook *WebhookConfig, ctx WebhookCont
 	return fmt.Errorf("webhook execution failed after %d attempts: %v", webhook.RetryCount, lastErr)
 }
 
+// redactUser 返回不含密码的用户配置，用于 webhook 模板
+func redactUser(user User) User {
+	user.GitPassword = ""
+	jobs := make([]Job, len(user.Jobs))
+	for i, job := range user.Jobs {
+		jobs[i] = redactJob(job)
+	}
+	user.Jobs = jobs
+	return user
+}
+
+// redactJob 返回隐藏远程地址认证信息和 forge 令牌后的任务配置，用于 webhook 和提交信息模板
+func redactJob(job Job) Job {
+	job.RemoteURL = redactURL(job.RemoteURL)
+	job.Forge.Token = ""
+	return job
+}
+
 // sendWebhookRequest 发送webhook请求
 func (wm *WebhookManager) sendWebhookRequest(webhook *WebhookConfig, body string) error {
 	req, err := http.NewRequest(webhook.Method, webhook.URL, bytes.NewBufferString(body))